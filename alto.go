@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/xml"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type AltoDocument struct {
+	XMLName  xml.Name   `xml:"alto"`
+	FileName string     `xml:"Description>sourceImageInformation>fileName"`
+	Pages    []AltoPage `xml:"Layout>Page"`
+}
+
+type AltoPage struct {
+	ID         string    `xml:"ID,attr"`
+	Width      float64   `xml:"WIDTH,attr"`
+	Height     float64   `xml:"HEIGHT,attr"`
+	PrintSpace AltoBlock `xml:"PrintSpace"`
+}
+
+// AltoBlock is a block of PrintSpace or ComposedBlock in document order:
+// TextBlock, ComposedBlock (which may be nested), Illustration etc.
+type AltoBlock struct {
+	XMLName xml.Name
+	AltoTextBlock
+	Children []AltoBlock `xml:",any"`
+}
+
+type AltoTextBlock struct {
+	ID        string         `xml:"ID,attr"`
+	TextLines []AltoTextLine `xml:"TextLine"`
+}
+
+type AltoTextLine struct {
+	HPos   float64      `xml:"HPOS,attr"`
+	VPos   float64      `xml:"VPOS,attr"`
+	Width  float64      `xml:"WIDTH,attr"`
+	Height float64      `xml:"HEIGHT,attr"`
+	Items  []AltoInline `xml:",any"`
+}
+
+// AltoInline is one of String, SP and HYP
+type AltoInline struct {
+	XMLName xml.Name
+	Content string   `xml:"CONTENT,attr"`
+	WC      *float64 `xml:"WC,attr"`
+	HPos    float64  `xml:"HPOS,attr"`
+	VPos    float64  `xml:"VPOS,attr"`
+	Width   float64  `xml:"WIDTH,attr"`
+	Height  float64  `xml:"HEIGHT,attr"`
+}
+
+func init() {
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "alto",
+		Descr:    "ALTO XML",
+		Patterns: []string{"*.xml", "**/*.xml"},
+		Excludes: []string{"mets.xml", "METS.xml"},
+		Parser:   OCRParserFunc(parseAltoFile),
+		Sniff:    sniffAlto,
+	})
+}
+
+func sniffAlto(raw []byte) bool {
+	return sniffXMLRoot(raw) == "alto"
+}
+
+func parseAltoFile(b *BookTextBuilder, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var doc AltoDocument
+	if err := xml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	for _, page := range doc.Pages {
+		b.AddPage()
+		if page.Width > 0 {
+			b.SetPageImage(doc.FileName,
+				altoRound(page.Width), altoRound(page.Height))
+		}
+		for _, block := range page.Blocks() {
+			for _, line := range block.SortedLines() {
+				text, conf, segs := line.TextAndConfidence()
+				b.AddLine(text, &BB{
+					X:          altoRound(line.HPos),
+					Y:          altoRound(line.VPos),
+					Width:      altoRound(line.Width),
+					Height:     altoRound(line.Height),
+					Confidence: conf,
+				}, segs...)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Blocks returns text blocks of the page in document order,
+// flattening ComposedBlocks
+func (p *AltoPage) Blocks() []AltoTextBlock {
+	blocks := []AltoTextBlock{}
+	var walk func(bs []AltoBlock)
+	walk = func(bs []AltoBlock) {
+		for _, b := range bs {
+			switch b.XMLName.Local {
+			case "TextBlock":
+				blocks = append(blocks, b.AltoTextBlock)
+			case "ComposedBlock":
+				walk(b.Children)
+			}
+		}
+	}
+	walk(p.PrintSpace.Children)
+	return blocks
+}
+
+// SortedLines returns lines in reading order: right to left
+// for vertical blocks, document order for horizontal ones
+func (tb *AltoTextBlock) SortedLines() []AltoTextLine {
+	lines := append([]AltoTextLine{}, tb.TextLines...)
+	vertical := 0
+	for _, l := range lines {
+		if l.IsVertical() {
+			vertical++
+		}
+	}
+	if vertical*2 > len(lines) {
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].HPos+lines[i].Width > lines[j].HPos+lines[j].Width
+		})
+	}
+	return lines
+}
+
+func (l *AltoTextLine) IsVertical() bool {
+	return l.Height > l.Width
+}
+
+// TextAndConfidence joins Strings of the line and returns the mean WC
+// of them (0 if no WC is given) and their boxes as segs
+func (l *AltoTextLine) TextAndConfidence() (string, float64, []*Seg) {
+	items := append([]AltoInline{}, l.Items...)
+	isVertical := l.IsVertical()
+	if isVertical {
+		strs := items[:0]
+		for _, it := range items {
+			if it.XMLName.Local != "SP" {
+				strs = append(strs, it)
+			}
+		}
+		items = strs
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].VPos < items[j].VPos
+		})
+	}
+
+	var (
+		sb   strings.Builder
+		pos  int
+		sum  float64
+		cnt  int
+		segs []*Seg
+	)
+	for _, it := range items {
+		switch it.XMLName.Local {
+		case "String":
+			n := utf8.RuneCountInString(it.Content)
+			if n > 0 && it.Width > 0 && it.Height > 0 {
+				seg := &Seg{
+					Pos: pos,
+					Len: n,
+					BB: BB{
+						X:      altoRound(it.HPos),
+						Y:      altoRound(it.VPos),
+						Width:  altoRound(it.Width),
+						Height: altoRound(it.Height),
+					},
+				}
+				if it.WC != nil {
+					seg.Confidence = *it.WC
+				}
+				segs = append(segs, seg)
+			}
+			sb.WriteString(it.Content)
+			pos += n
+			if it.WC != nil {
+				sum += *it.WC
+				cnt++
+			}
+		case "SP":
+			sb.WriteString(" ")
+			pos++
+		case "HYP":
+			sb.WriteString(it.Content)
+			pos += utf8.RuneCountInString(it.Content)
+		}
+	}
+
+	if cnt == 0 {
+		return sb.String(), 0, segs
+	}
+	return sb.String(), sum / float64(cnt), segs
+}
+
+func altoRound(f float64) int {
+	return int(math.Round(f))
+}
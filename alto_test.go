@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestAltoBlocks(t *testing.T) {
+	t.Parallel()
+
+	raw := `<alto xmlns="http://www.loc.gov/standards/alto/ns-v3#"><Layout>
+<Page ID="P1"><PrintSpace>
+<TextBlock ID="TB1"><TextLine/></TextBlock>
+<ComposedBlock ID="CB1">
+  <TextBlock ID="TB2"/>
+  <ComposedBlock ID="CB2"><TextBlock ID="TB3"/></ComposedBlock>
+  <TextBlock ID="TB4"/>
+</ComposedBlock>
+<Illustration ID="IL1"/>
+<TextBlock ID="TB5"/>
+</PrintSpace></Page>
+</Layout></alto>`
+
+	var doc AltoDocument
+	if err := xml.Unmarshal([]byte(raw), &doc); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, b := range doc.Pages[0].Blocks() {
+		got = append(got, b.ID)
+	}
+	want := []string{"TB1", "TB2", "TB3", "TB4", "TB5"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Blocks mismatch (-want +got):\n%s", diff)
+	}
+	if n := len(doc.Pages[0].Blocks()[0].TextLines); n != 1 {
+		t.Errorf("TextLines of TB1 => %d, want 1", n)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// analyzers of text and text.kana
+const (
+	bigramTokenizer = "my_bigram_tokenizer"
+	textAnalyzer    = "my_icu_ngram_analyzer"
+	kanaAnalyzer    = "my_kana_ngram_analyzer"
+	itaijiFilter    = "my_itaiji"
+	kanaFoldFilter  = "my_kana_fold"
+)
+
+// AnalysisSettings returns the analysis of the index, with the itaiji
+// table t:
+//
+//	text:      icu => itaiji => odoriji expanded => bigram
+//	text.kana: icu => itaiji => odoriji expanded => kana folded => bigram
+//
+// NormalizeText does the same except icu.
+func AnalysisSettings(t ItaijiTable) *types.IndexSettingsAnalysis {
+	tokenizer := types.NewNGramTokenizer()
+	tokenizer.MinGram = 2
+	tokenizer.MaxGram = 2
+
+	charFilters := []string{"icu_normalizer", itaijiFilter}
+	charFilters = append(charFilters, odorijiCharFilterNames...)
+
+	text := types.NewCustomAnalyzer()
+	text.CharFilter = charFilters
+	text.Tokenizer = bigramTokenizer
+
+	kana := types.NewCustomAnalyzer()
+	kana.CharFilter = append(append([]string{}, charFilters...), kanaFoldFilter)
+	kana.Tokenizer = bigramTokenizer
+
+	cfs := odorijiCharFilters()
+	cfs[itaijiFilter] = t.CharFilter()
+	cfs[kanaFoldFilter] = kanaCharFilter()
+
+	return &types.IndexSettingsAnalysis{
+		Analyzer: map[string]types.Analyzer{
+			textAnalyzer: text,
+			kanaAnalyzer: kana,
+		},
+		CharFilter: cfs,
+		Tokenizer: map[string]types.Tokenizer{
+			bigramTokenizer: tokenizer,
+		},
+	}
+}
+
+// NormalizeText normalizes s as the text field (text.kana if kana) does;
+// src[i] is the byte range in s of the character which the i-th byte of
+// the result, at a character boundary, comes from
+func NormalizeText(s string, kana bool) (string, [][2]int) {
+	folded, src1 := mapRunes(s, currentItaijiTable().Fold)
+	expanded, src2 := ExpandOdoriji(folded)
+	src := make([][2]int, len(src2))
+	for i, r := range src2 {
+		src[i] = [2]int{src1[r[0]][0], src1[r[1]-1][1]}
+	}
+	if kana {
+		// keeps the offsets
+		expanded = FoldKana(expanded)
+	}
+	return expanded, src
+}
+
+// mapRunes maps the characters of s by f, with the offsets as
+// NormalizeText
+func mapRunes(s string, f func(rune) rune) (string, [][2]int) {
+	b := make([]byte, 0, len(s))
+	src := make([][2]int, 0, len(s))
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		n := len(b)
+		b = utf8.AppendRune(b, f(r))
+		for j := n; j < len(b); j++ {
+			src = append(src, [2]int{i, i + w})
+		}
+		i += w
+	}
+	return string(b), src
+}
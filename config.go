@@ -17,6 +17,30 @@ type Config struct {
 	IsBulkSubdir  bool
 	AbortOnError  bool
 	CacheSize     int64
+	// max hit documents of a search; default if unset, unlimited if 0
+	SearchMaxHits *int
+	// manifest
+	ManifestProvider string
+	ManifestURL      string
+	ManifestDir      string
+	// hosts of manifest URLs given on register besides that of ManifestURL
+	ManifestHosts []string
+	// manifest fetching; defaults if 0 or, for the pointers, unset
+	ManifestTimeoutSec  int
+	ManifestRetryNum    *int
+	ManifestBackoffMSec int
+	ManifestHostConnNum *int
+	// no cache if empty
+	ManifestCacheDir string
+	// typed fields from manifest metadata; defaults if empty
+	MetadataFields []MetadataField
+	// confusion table of the fuzzy search; no variants if empty
+	ConfusionFile string
+	// itaiji table of text; no variants if empty
+	ItaijiFile string
+	// mecab type (unidic-<type>) converting queries to kana for
+	// mode=reading; unavailable if empty
+	ReadingMecabType string
 }
 
 func NewConfig() (*Config, error) {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+/* ConfusionTable */
+// ConfusionTable maps a character to its visually similar characters,
+// which OCR confuses
+type ConfusionTable map[rune][]rune
+
+// table used by the fuzzy search; empty if not configured
+var (
+	confusionMu    sync.RWMutex
+	confusionTable = ConfusionTable{}
+)
+
+// currentConfusionTable returns the table in use, which is not modified
+// but replaced by setConfusionTable
+func currentConfusionTable() ConfusionTable {
+	confusionMu.RLock()
+	defer confusionMu.RUnlock()
+	return confusionTable
+}
+
+func setConfusionTable(ct ConfusionTable) {
+	confusionMu.Lock()
+	defer confusionMu.Unlock()
+	confusionTable = ct
+}
+
+// LoadConfusionTable reads the groups of characters, a group a line
+func LoadConfusionTable(path string) (ConfusionTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("confusion table: %s", err)
+	}
+	defer f.Close()
+
+	ct := ConfusionTable{}
+	sc := bufio.NewScanner(f)
+	for ln := 1; sc.Scan(); ln++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		group := []rune{}
+		for _, s := range strings.Fields(line) {
+			if utf8.RuneCountInString(s) != 1 {
+				return nil, fmt.Errorf("confusion table: line %d: a character expected: %s", ln, s)
+			}
+			r, _ := utf8.DecodeRuneInString(s)
+			group = append(group, r)
+		}
+		ct.Add(group...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("confusion table: %s", err)
+	}
+	return ct, nil
+}
+
+// Add makes the characters variants of each other
+func (ct ConfusionTable) Add(group ...rune) {
+	for _, a := range group {
+		for _, b := range group {
+			if a != b && !slices.Contains(ct[a], b) {
+				ct[a] = append(ct[a], b)
+			}
+		}
+	}
+}
+
+// Variants returns r and its variants
+func (ct ConfusionTable) Variants(r rune) []rune {
+	return append([]rune{r}, ct[r]...)
+}
+
+/* ConfusionResult */
+type ConfusionResult struct {
+	// characters having variants in the table
+	Characters int `json:"characters"`
+}
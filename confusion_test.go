@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestLoadConfusionTable(t *testing.T) {
+	t.Parallel()
+
+	ct, err := LoadConfusionTable("confusion.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]rune("己已巳"), ct.Variants('己')); diff != "" {
+		t.Errorf("Variants(己) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]rune("力カ刀"), ct.Variants('力')); diff != "" {
+		t.Errorf("Variants(力) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]rune("字"), ct.Variants('字')); diff != "" {
+		t.Errorf("Variants(字) mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// not parallel, as confusionTable is set
+func TestFuzzyTerm(t *testing.T) {
+	ct, err := LoadConfusionTable("confusion.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := currentConfusionTable()
+	setConfusionTable(ct)
+	defer setConfusionTable(old)
+
+	n, err := ParseQuery("自己")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetFuzzy()
+	n.SetUnknown()
+
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"自己", 0},
+		{"自已", 1},
+		{"自巳", 1},
+		{"自□", 1},
+	}
+	for _, tt := range tests {
+		if !n.MatchesText(tt.text) {
+			t.Errorf("MatchesText(%s) => false", tt.text)
+		}
+		if got := n.EditDistance(tt.text); got != tt.want {
+			t.Errorf("EditDistance(%s) => %d, want %d", tt.text, got, tt.want)
+		}
+	}
+	if n.MatchesText("自分") {
+		t.Errorf("MatchesText(自分) => true")
+	}
+
+	frags := n.WildcardFragments("是は自已の", 50)
+	want := []string{`是は<em class="hltw1">自已</em>の`}
+	if diff := cmp.Diff(want, frags); diff != "" {
+		t.Errorf("WildcardFragments mismatch (-want +got):\n%s", diff)
+	}
+	if d, ok := n.MatchDistance("hltw1", "自已"); !ok || d != 1 {
+		t.Errorf("MatchDistance(hltw1) => %d, %v, want 1, true", d, ok)
+	}
+	if _, ok := n.MatchDistance("hlt1", "自已"); ok {
+		t.Errorf("MatchDistance(hlt1) => true, want false")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -55,30 +56,52 @@ func GetCount(es *ES) func(c echo.Context) error {
 	}
 }
 
+// GetFormats
+func GetFormats() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, OCRFormats())
+	}
+}
+
 // GetNgramSearch
 func GetNgramSearch(es *ES) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		var sp TextSearchParam
 		err := echo.QueryParamsBinder(c).
-			BindWithDelimiter("q[]", &sp.Words, ",").
-			BindWithDelimiter("q", &sp.Words, ",").
+			// "," is parsed as AND; see ParseQuery
+			Strings("q[]", &sp.Words).
+			Strings("q", &sp.Words).
 			CustomFunc("el[]", ELevelValueBinder(&sp)).
 			CustomFunc("el", ELevelValueBinder(&sp)).
 			BindWithDelimiter("tag[]", &sp.Tags, ",").
 			BindWithDelimiter("tag", &sp.Tags, ",").
 			BindWithDelimiter("bid[]", &sp.Bids, ",").
 			BindWithDelimiter("bid", &sp.Bids, ",").
+			// values may include ","
+			Strings("license[]", &sp.Licenses).
+			Strings("license", &sp.Licenses).
+			Strings("attribution[]", &sp.Attributions).
+			Strings("attribution", &sp.Attributions).
 			Int("page", &sp.Page).
 			Int("perPage", &sp.PerPage).
+			Float64("minConf", &sp.MinConfidence).
+			Bool("canvas", &sp.Canvas).
+			Bool("unknown", &sp.Unknown).
+			Bool("fuzzy", &sp.Fuzzy).
+			Bool("kana", &sp.Kana).
+			String("mode", &sp.Mode).
 			BindError()
+		if err == nil {
+			err = sp.BindBiblioFilters(c.QueryParams())
+		}
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest,
 				fmt.Errorf("query error: %s", err))
 		}
 
-		if len(sp.Words) == 0 {
+		if err := sp.ParseQuery(); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest,
-				fmt.Errorf("query missing"))
+				fmt.Errorf("query error: %s", err))
 		}
 
 		var sr *TextSearchResult
@@ -89,6 +112,9 @@ func GetNgramSearch(es *ES) func(c echo.Context) error {
 			sr = cache.(*TextSearchResult)
 		} else {
 			data, err := es.SearchText(&sp)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
 
 			sr, err = NewTextSearchResult(&sp, data)
 			if err != nil {
@@ -119,16 +145,27 @@ func GetNgramSearch(es *ES) func(c echo.Context) error {
 		}
 
 		return c.JSON(http.StatusOK, &TextSearchResult{
-			Filters: sr.Filters,
-			Bibl:    sr.Bibl,
-			Matches: sr.Matches[from:till],
-			Total:   total,
-			Page:    page,
-			PerPage: perPage,
+			Filters:     sr.Filters,
+			Bibl:        sr.Bibl,
+			Matches:     sr.Matches[from:till],
+			Total:       total,
+			BookTotal:   sr.BookTotal,
+			DocTotal:    sr.DocTotal,
+			Truncated:   sr.Truncated,
+			Page:        page,
+			Approximate: sr.Approximate,
+			PerPage:     perPage,
 		})
 	}
 }
 
+// GetItaiji
+func GetItaiji() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		return c.String(http.StatusOK, currentItaijiTable().String())
+	}
+}
+
 // /* POST */
 
 // PostRegister
@@ -171,18 +208,24 @@ func PostRegister(es *ES) func(c echo.Context) error {
 		}
 
 		// set metadata from manifest
-		if err := bt.FetchKokushoMetadata(); err != nil {
+		if err := bt.FetchMetadata(rp.Manifest); err != nil {
 			return echo.NewHTTPError(
 				http.StatusBadRequest, fmt.Errorf("get metadata: %s", err))
 		}
 
+		// map OCR pages to canvases
+		pa := bt.AlignPages()
+
 		// index it
 		if err := es.IndexBookData(bt); err != nil {
 			return echo.NewHTTPError(
 				http.StatusBadRequest, fmt.Errorf("IndexBookData: %s", err))
 		}
 
-		return c.JSON(http.StatusOK, rp)
+		return c.JSON(http.StatusOK, &RegisterResult{
+			RegisterParam: &rp,
+			Alignment:     pa,
+		})
 	}
 }
 
@@ -212,3 +255,108 @@ func PostBulkRegister(es *ES) func(c echo.Context) error {
 		return c.JSON(http.StatusOK, csv)
 	}
 }
+
+// PostMetadataRefresh
+func PostMetadataRefresh(es *ES) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		// get params
+		var rp MetadataRefreshParam
+		if err := c.Bind(&rp); err != nil {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("bind param: %s", err))
+		}
+		if !rp.All && len(rp.Bids) == 0 {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("param \"bids\" or \"all\" missing"))
+		}
+
+		res, err := rp.Refresh(es)
+		if err != nil {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("refresh error: %s", err))
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// PostItaiji
+func PostItaiji(es *ES) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		fh, err := c.FormFile("table")
+		if err != nil {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("param \"table\": %s", err))
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("param \"table\": %s", err))
+		}
+		defer f.Close()
+
+		t, err := ParseItaijiTable(f)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		res, err := ApplyItaijiTable(es, t, cfg.ItaijiFile)
+		if err != nil {
+			return itaijiHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// PostItaijiReload
+func PostItaijiReload(es *ES) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if cfg.ItaijiFile == "" {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("ItaijiFile not configured"))
+		}
+		t, err := LoadItaijiTable(cfg.ItaijiFile)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		// read from the file, not to be saved
+		res, err := ApplyItaijiTable(es, t, "")
+		if err != nil {
+			return itaijiHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// itaijiHTTPError returns the error of ApplyItaijiTable; conflict if the
+// index is busy
+func itaijiHTTPError(err error) error {
+	code := http.StatusInternalServerError
+	if errors.Is(err, errIndexBusy) {
+		code = http.StatusConflict
+	}
+	return echo.NewHTTPError(code, fmt.Errorf("apply itaiji table: %s", err))
+}
+
+// PostConfusionReload
+func PostConfusionReload(es *ES) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if cfg.ConfusionFile == "" {
+			return echo.NewHTTPError(
+				http.StatusBadRequest, fmt.Errorf("ConfusionFile not configured"))
+		}
+		ct, err := LoadConfusionTable(cfg.ConfusionFile)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		setConfusionTable(ct)
+		// results of fuzzy searches
+		es.Cache.Clear()
+
+		return c.JSON(http.StatusOK, &ConfusionResult{Characters: len(ct)})
+	}
+}
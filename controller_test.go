@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/labstack/echo/v4"
+)
+
+// not parallel, as cfg is set
+func TestGetNgramSearchMode(t *testing.T) {
+	orig := cfg
+	defer func() { cfg = orig }()
+
+	tests := []struct {
+		readingMecabType string
+		params           url.Values
+		want             string
+	}{
+		{"", url.Values{"q": {"思ふ"}, "mode": {"reading"}},
+			"ReadingMecabType not set"},
+		{"chuko", url.Values{"q": {"思*"}, "mode": {"reading"}},
+			"wildcard not allowed for reading"},
+		{"chuko", url.Values{"q": {"思ふ"}, "mode": {"reading"}, "kana": {"true"}},
+			"cannot be used with unknown, fuzzy or kana"},
+		{"chuko", url.Values{"q": {"思ふ"}, "mode": {"sound"}},
+			"unknown mode: sound"},
+	}
+	for _, tt := range tests {
+		cfg = &Config{ReadingMecabType: tt.readingMecabType}
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api/search?"+tt.params.Encode(), nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+
+		err := GetNgramSearch(&ES{})(c)
+		he, ok := err.(*echo.HTTPError)
+		if !ok || he.Code != http.StatusBadRequest {
+			t.Errorf("%s: GetNgramSearch => %v, want bad request", tt.params.Encode(), err)
+			continue
+		}
+		if msg := he.Message.(error).Error(); !strings.Contains(msg, tt.want) {
+			t.Errorf("%s: GetNgramSearch => %s, want %s", tt.params.Encode(), msg, tt.want)
+		}
+	}
+}
+
+// not parallel, as cfg and confusionTable are set
+func TestPostConfusionReload(t *testing.T) {
+	orig := cfg
+	defer func() { cfg = orig }()
+	old := currentConfusionTable()
+	defer setConfusionTable(old)
+
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 100,
+		MaxCost:     100,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := &ES{Cache: cache}
+
+	setConfusionTable(ConfusionTable{})
+	cfg = &Config{ConfusionFile: "confusion.txt"}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/confusion/reload", nil)
+	rec := httptest.NewRecorder()
+	if err := PostConfusionReload(es)(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("PostConfusionReload => %d", rec.Code)
+	}
+	if len(currentConfusionTable()) == 0 {
+		t.Errorf("PostConfusionReload: table not reloaded")
+	}
+
+	cfg = &Config{}
+	err = PostConfusionReload(es)(e.NewContext(req, httptest.NewRecorder()))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Errorf("PostConfusionReload without ConfusionFile => %v, want bad request", err)
+	}
+}
@@ -4,6 +4,8 @@ go 1.21.3
 
 require (
 	github.com/BurntSushi/toml v1.3.2
+	github.com/dgraph-io/ristretto v0.1.1
+	github.com/dustin/go-humanize v1.0.1
 	github.com/elastic/elastic-transport-go/v8 v8.3.0
 	github.com/elastic/go-elasticsearch/v8 v8.11.1
 	github.com/google/go-cmp v0.6.0
@@ -15,8 +17,6 @@ require (
 	github.com/alecthomas/participle/v2 v2.1.1 // indirect
 	github.com/alvaroloes/enumer v1.1.2 // indirect
 	github.com/cespare/xxhash/v2 v2.1.1 // indirect
-	github.com/dgraph-io/ristretto v0.1.1 // indirect
-	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// hOCR classes treated as a line
+var hocrLineClasses = []string{
+	"ocr_line",
+	"ocrx_line",
+	"ocr_textfloat",
+	"ocr_header",
+	"ocr_caption",
+}
+
+type HocrWord struct {
+	Text  string
+	BBox  *BB
+	WConf float64 // x_wconf (0-100); -1 if not given
+}
+
+type HocrLine struct {
+	BBox  *BB
+	Words []*HocrWord
+}
+
+func init() {
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "hocr",
+		Descr:    "hOCR HTML (e.g. Tesseract)",
+		Patterns: []string{"*.hocr", "**/*.hocr", "*.html", "**/*.html"},
+		Parser:   OCRParserFunc(parseHocrFile),
+		Sniff:    sniffHocr,
+	})
+}
+
+func sniffHocr(raw []byte) bool {
+	return sniffXMLRoot(raw) == "html" && bytes.Contains(raw, []byte("ocr_page"))
+}
+
+func parseHocrFile(b *BookTextBuilder, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := xml.NewDecoder(f)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var (
+		// classes of open elements
+		stack []string
+		line  *HocrLine
+		word  *HocrWord
+		// text directly under a line without ocrx_word
+		sb strings.Builder
+	)
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			class, title := "", ""
+			for _, a := range t.Attr {
+				switch a.Name.Local {
+				case "class":
+					class = a.Value
+				case "title":
+					title = a.Value
+				}
+			}
+			stack = append(stack, class)
+
+			switch {
+			case hasHocrClass(class, "ocr_page"):
+				b.AddPage()
+				if bb := parseHocrBBox(title); bb != nil {
+					b.SetPageImage(parseHocrImage(title), bb.Width, bb.Height)
+				}
+			case line == nil && hasHocrClass(class, hocrLineClasses...):
+				line = &HocrLine{BBox: parseHocrBBox(title)}
+				sb.Reset()
+			case line != nil && hasHocrClass(class, "ocrx_word"):
+				word = &HocrWord{
+					BBox:  parseHocrBBox(title),
+					WConf: parseHocrWConf(title),
+				}
+			}
+		case xml.CharData:
+			if word != nil {
+				word.Text += string(t)
+			} else if line != nil {
+				sb.Write(t)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			class := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			switch {
+			case word != nil && hasHocrClass(class, "ocrx_word"):
+				word.Text = strings.TrimSpace(word.Text)
+				if word.Text != "" {
+					line.Words = append(line.Words, word)
+				}
+				word = nil
+			case line != nil && hasHocrClass(class, hocrLineClasses...):
+				if len(line.Words) == 0 {
+					if s := strings.TrimSpace(sb.String()); s != "" {
+						line.Words = []*HocrWord{{Text: s, WConf: -1}}
+					}
+				}
+				if len(line.Words) > 0 {
+					text, conf, segs := line.TextAndConfidence()
+					bb := line.BBox
+					if bb == nil {
+						bb = &BB{}
+					}
+					bb.Confidence = conf
+					b.AddLine(text, bb, segs...)
+				}
+				line = nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// TextAndConfidence joins words of the line and returns the mean
+// x_wconf of them scaled to [0, 1] (0 if no x_wconf is given) and
+// their boxes as segs
+func (l *HocrLine) TextAndConfidence() (string, float64, []*Seg) {
+	texts := make([]string, len(l.Words))
+	var (
+		sum float64
+		cnt int
+	)
+	for i, w := range l.Words {
+		texts[i] = w.Text
+		if w.WConf >= 0 {
+			sum += w.WConf
+			cnt++
+		}
+	}
+
+	text, offsets := JoinWordsWithOffsets(texts)
+	segs := []*Seg{}
+	for i, w := range l.Words {
+		if w.BBox == nil {
+			continue
+		}
+		seg := &Seg{
+			Pos: offsets[i],
+			Len: utf8.RuneCountInString(w.Text),
+			BB:  *w.BBox,
+		}
+		if w.WConf >= 0 {
+			seg.Confidence = w.WConf / 100
+		}
+		segs = append(segs, seg)
+	}
+
+	if cnt == 0 {
+		return text, 0, segs
+	}
+	return text, sum / float64(cnt) / 100, segs
+}
+
+func hasHocrClass(class string, names ...string) bool {
+	for _, c := range strings.Fields(class) {
+		for _, n := range names {
+			if c == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseHocrBBox parses "bbox x0 y0 x1 y1" in a title attribute
+func parseHocrBBox(title string) *BB {
+	for _, prop := range strings.Split(title, ";") {
+		f := strings.Fields(prop)
+		if len(f) != 5 || f[0] != "bbox" {
+			continue
+		}
+		var v [4]int
+		for i := 0; i < 4; i++ {
+			n, err := strconv.Atoi(f[i+1])
+			if err != nil {
+				return nil
+			}
+			v[i] = n
+		}
+		return &BB{
+			X:      v[0],
+			Y:      v[1],
+			Width:  v[2] - v[0],
+			Height: v[3] - v[1],
+		}
+	}
+	return nil
+}
+
+// parseHocrImage parses `image "path"` in a title attribute
+func parseHocrImage(title string) string {
+	for _, prop := range strings.Split(title, ";") {
+		prop = strings.TrimSpace(prop)
+		if !strings.HasPrefix(prop, "image ") {
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(prop[6:]), "\"'")
+		return filepath.Base(name)
+	}
+	return ""
+}
+
+// parseHocrWConf parses "x_wconf n" in a title attribute
+func parseHocrWConf(title string) float64 {
+	for _, prop := range strings.Split(title, ";") {
+		f := strings.Fields(prop)
+		if len(f) != 2 || f[0] != "x_wconf" {
+			continue
+		}
+		n, err := strconv.ParseFloat(f[1], 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	return -1
+}
+
+// JoinWords joins words with a space except between CJK characters
+func JoinWords(words []string) string {
+	s, _ := JoinWordsWithOffsets(words)
+	return s
+}
+
+// JoinWordsWithOffsets is JoinWords also returning the offsets (runes)
+// of the words in the joined string
+func JoinWordsWithOffsets(words []string) (string, []int) {
+	var sb strings.Builder
+	offsets := make([]int, len(words))
+	pos := 0
+	for i, w := range words {
+		if i > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(words[i-1])
+			next, _ := utf8.DecodeRuneInString(w)
+			if !isCJK(prev) && !isCJK(next) {
+				sb.WriteString(" ")
+				pos++
+			}
+		}
+		offsets[i] = pos
+		sb.WriteString(w)
+		pos += utf8.RuneCountInString(w)
+	}
+	return sb.String(), offsets
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303f) || // CJK symbols and punctuation
+		(r >= 0xff00 && r <= 0xffef) // halfwidth and fullwidth forms
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+/* ItaijiTable */
+// ItaijiTable maps itaiji and kyūjitai to the standard characters, e.g.
+// 國 to 国; applied to text at index and query time
+type ItaijiTable map[rune]rune
+
+var (
+	itaijiMu    sync.RWMutex
+	itaijiTable = ItaijiTable{}
+	// generation of the table last applied
+	itaijiGen int
+)
+
+// currentItaijiTable returns the table in use, which is not modified but
+// replaced by setItaijiTable
+func currentItaijiTable() ItaijiTable {
+	itaijiMu.RLock()
+	defer itaijiMu.RUnlock()
+	return itaijiTable
+}
+
+func setItaijiTable(t ItaijiTable) {
+	itaijiMu.Lock()
+	defer itaijiMu.Unlock()
+	itaijiTable = t
+}
+
+// ParseItaijiTable reads lines of the standard character followed by its
+// variants, e.g. "国 國 囯"; "#" starts a comment
+func ParseItaijiTable(r io.Reader) (ItaijiTable, error) {
+	t := ItaijiTable{}
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 {
+			return nil, fmt.Errorf("itaiji table: line %d: variants missing", ln)
+		}
+		rs := make([]rune, len(fields))
+		for i, s := range fields {
+			if utf8.RuneCountInString(s) != 1 {
+				return nil, fmt.Errorf("itaiji table: line %d: a character expected: %s", ln, s)
+			}
+			rs[i], _ = utf8.DecodeRuneInString(s)
+		}
+		for _, v := range rs[1:] {
+			if std, ok := t[v]; ok && std != rs[0] {
+				return nil, fmt.Errorf("itaiji table: line %d: %c is also a variant of %c", ln, v, std)
+			}
+			if v != rs[0] {
+				t[v] = rs[0]
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("itaiji table: %s", err)
+	}
+	for v, std := range t {
+		if _, ok := t[std]; ok {
+			return nil, fmt.Errorf("itaiji table: %c of %c is also a variant", std, v)
+		}
+	}
+	return t, nil
+}
+
+// LoadItaijiTable reads the table of the file path
+func LoadItaijiTable(path string) (ItaijiTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("itaiji table: %s", err)
+	}
+	defer f.Close()
+	return ParseItaijiTable(f)
+}
+
+// SaveItaijiTable writes t to the file path, which is replaced at once by
+// a temporary file so as not to be left half written
+func SaveItaijiTable(path string, t ItaijiTable) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".itaiji-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(t.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Fold returns the standard character of r
+func (t ItaijiTable) Fold(r rune) rune {
+	if std, ok := t[r]; ok {
+		return std
+	}
+	return r
+}
+
+// String returns the table in the format of ParseItaijiTable
+func (t ItaijiTable) String() string {
+	groups := map[rune][]rune{}
+	for v, std := range t {
+		groups[std] = append(groups[std], v)
+	}
+	stds := make([]rune, 0, len(groups))
+	for std := range groups {
+		stds = append(stds, std)
+	}
+	slices.Sort(stds)
+
+	var sb strings.Builder
+	for _, std := range stds {
+		vs := groups[std]
+		slices.Sort(vs)
+		sb.WriteRune(std)
+		for _, v := range vs {
+			sb.WriteString(" ")
+			sb.WriteRune(v)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// CharFilter returns the char filter which folds the variants
+func (t ItaijiTable) CharFilter() *types.MappingCharFilter {
+	vs := make([]rune, 0, len(t))
+	for v := range t {
+		vs = append(vs, v)
+	}
+	slices.Sort(vs)
+
+	cf := types.NewMappingCharFilter()
+	for _, v := range vs {
+		cf.Mappings = append(cf.Mappings, fmt.Sprintf("%c=>%c", v, t[v]))
+	}
+	if len(cf.Mappings) == 0 {
+		// a mapping char filter needs a rule
+		cf.Mappings = []string{"国=>国"}
+	}
+	return cf
+}
+
+/* ItaijiResult */
+type ItaijiResult struct {
+	// variants in the table
+	Variants int `json:"variants"`
+	// task of ES to reindex the documents
+	Task string `json:"task"`
+}
+
+// interval to poll the reindex task
+const itaijiTaskInterval = 5 * time.Second
+
+var (
+	// held while a table is applied
+	itaijiApplyMu sync.Mutex
+	// reindex task of the table last applied until it completes
+	itaijiTask string
+)
+
+// ApplyItaijiTable uses t for the index, saved to path if not empty, and
+// then for the search once the documents are reindexed in place in the
+// background; until the reindex task completes, the search folds by the
+// previous table. errIndexBusy if the task of a previous table is running.
+func ApplyItaijiTable(es *ES, t ItaijiTable, path string) (*ItaijiResult, error) {
+	itaijiApplyMu.Lock()
+	defer itaijiApplyMu.Unlock()
+	if itaijiTask != "" {
+		return nil, fmt.Errorf("%w: reindex task %s running", errIndexBusy, itaijiTask)
+	}
+
+	if err := es.UpdateAnalysis(t); err != nil {
+		return nil, err
+	}
+	// kept for restarts, as the index uses t
+	if path != "" {
+		if err := SaveItaijiTable(path, t); err != nil {
+			if rerr := es.UpdateAnalysis(currentItaijiTable()); rerr != nil {
+				return nil, fmt.Errorf("save itaiji table: %s; roll back: %s", err, rerr)
+			}
+			return nil, fmt.Errorf("save itaiji table: %s", err)
+		}
+	}
+	gen := nextItaijiGen()
+
+	task, err := es.ReindexText()
+	if err != nil {
+		// documents analyzed by t from now on
+		switchItaijiTable(es, t, gen)
+		return nil, fmt.Errorf("reindex: %s", err)
+	}
+
+	itaijiTask = task
+	go func() {
+		if err := es.WaitTask(task, itaijiTaskInterval); err != nil {
+			// the search keeps the previous table; to be applied again
+			fmt.Printf("reindex with itaiji table: %s\n", err)
+		} else {
+			switchItaijiTable(es, t, gen)
+		}
+
+		itaijiApplyMu.Lock()
+		itaijiTask = ""
+		itaijiApplyMu.Unlock()
+	}()
+	return &ItaijiResult{Variants: len(t), Task: task}, nil
+}
+
+// nextItaijiGen returns the generation of the table being applied
+func nextItaijiGen() int {
+	itaijiMu.Lock()
+	defer itaijiMu.Unlock()
+	itaijiGen++
+	return itaijiGen
+}
+
+// switchItaijiTable uses t for the search unless a later table is applied
+func switchItaijiTable(es *ES, t ItaijiTable, gen int) {
+	if setItaijiTableOf(t, gen) {
+		es.Cache.Clear()
+	}
+}
+
+func setItaijiTableOf(t ItaijiTable, gen int) bool {
+	itaijiMu.Lock()
+	defer itaijiMu.Unlock()
+	if gen != itaijiGen {
+		return false
+	}
+	itaijiTable = t
+	return true
+}
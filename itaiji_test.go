@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseItaijiTable(t *testing.T) {
+	t.Parallel()
+
+	tbl, err := LoadItaijiTable("itaiji.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v, want := range map[rune]rune{'國': '国', '圀': '国', '澤': '沢', '𠮷': '吉', '国': '国'} {
+		if got := tbl.Fold(v); got != want {
+			t.Errorf("Fold(%c) => %c, want %c", v, got, want)
+		}
+	}
+
+	// round trip
+	again, err := ParseItaijiTable(strings.NewReader(tbl.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != tbl.String() {
+		t.Errorf("String => %s, want %s", again.String(), tbl.String())
+	}
+
+	cf := tbl.CharFilter()
+	if !slices.Contains(cf.Mappings, "國=>国") {
+		t.Errorf("CharFilter: 國=>国 missing")
+	}
+	if cf := (ItaijiTable{}).CharFilter(); len(cf.Mappings) != 1 {
+		t.Errorf("CharFilter of empty => %v", cf.Mappings)
+	}
+
+	for _, s := range []string{
+		"国",
+		"国 國 国2",
+		"国 國\n囯 國",
+		"国 國\n國 囯",
+	} {
+		if _, err := ParseItaijiTable(strings.NewReader(s)); err == nil {
+			t.Errorf("ParseItaijiTable(%q) => nil, want error", s)
+		}
+	}
+}
+
+func TestSaveItaijiTable(t *testing.T) {
+	t.Parallel()
+
+	tbl, err := ParseItaijiTable(strings.NewReader("国 國 囯\n学 學"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "itaiji.txt")
+	if err := os.WriteFile(path, []byte("沢 澤\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveItaijiTable(path, tbl); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadItaijiTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != tbl.String() {
+		t.Errorf("LoadItaijiTable => %s, want %s", got.String(), tbl.String())
+	}
+	// no temporary file left
+	if es, _ := os.ReadDir(dir); len(es) != 1 {
+		t.Errorf("files in dir: %d, want 1", len(es))
+	}
+}
+
+// not parallel, as the itaiji table is set
+func TestSetItaijiTableOf(t *testing.T) {
+	old := currentItaijiTable()
+	defer setItaijiTable(old)
+
+	t1 := ItaijiTable{'國': '国'}
+	t2 := ItaijiTable{'學': '学'}
+	g1 := nextItaijiGen()
+	g2 := nextItaijiGen()
+
+	// the reindex of t2 completed before that of t1
+	if !setItaijiTableOf(t2, g2) {
+		t.Errorf("setItaijiTableOf(t2) => false")
+	}
+	if setItaijiTableOf(t1, g1) {
+		t.Errorf("setItaijiTableOf(t1) => true, after t2")
+	}
+	if got := currentItaijiTable(); got.String() != t2.String() {
+		t.Errorf("currentItaijiTable => %s, want %s", got.String(), t2.String())
+	}
+}
+
+// not parallel, as itaijiTask is set
+func TestApplyItaijiTableBusy(t *testing.T) {
+	itaijiApplyMu.Lock()
+	itaijiTask = "node:1"
+	itaijiApplyMu.Unlock()
+	defer func() {
+		itaijiApplyMu.Lock()
+		itaijiTask = ""
+		itaijiApplyMu.Unlock()
+	}()
+
+	// rejected before ES is used
+	_, err := ApplyItaijiTable(&ES{}, ItaijiTable{'國': '国'}, "")
+	if !errors.Is(err, errIndexBusy) {
+		t.Fatalf("ApplyItaijiTable => %v, want errIndexBusy", err)
+	}
+	he, ok := itaijiHTTPError(err).(*echo.HTTPError)
+	if !ok || he.Code != http.StatusConflict {
+		t.Errorf("itaijiHTTPError => %v, want conflict", he)
+	}
+}
+
+func TestTaskFailures(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		raw  string
+		want int
+	}{
+		{"", 0},
+		{`{"updated":10,"failures":[]}`, 0},
+		{`{"updated":8,"failures":[{"id":"a"},{"id":"b"}]}`, 2},
+	}
+	for _, tt := range tests {
+		got, err := taskFailures(json.RawMessage(tt.raw))
+		if err != nil {
+			t.Errorf("taskFailures(%s): %s", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("taskFailures(%s) => %d, want %d", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// not parallel, as the itaiji table is set
+func TestItaijiNormalize(t *testing.T) {
+	tbl, err := LoadItaijiTable("itaiji.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := currentItaijiTable()
+	setItaijiTable(tbl)
+	defer setItaijiTable(old)
+
+	text := "𠮷野の國學は々"
+	got, src := NormalizeText(text, false)
+	if got != "吉野の国学は々" {
+		t.Errorf("NormalizeText => %s", got)
+	}
+	// 学 from 學
+	if r := src[len("吉野の国")]; text[r[0]:r[1]] != "學" {
+		t.Errorf("NormalizeText: offsets %v", r)
+	}
+
+	n, err := ParseQuery("国学")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.IsVariant("國學") {
+		t.Errorf("IsVariant(國學) => false")
+	}
+	if n.IsVariant("国学") {
+		t.Errorf("IsVariant(国学) => true")
+	}
+	if !n.MatchesFragment("の國學は") {
+		t.Errorf("MatchesFragment => false")
+	}
+
+	w, err := ParseQuery("吉?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags := w.WildcardFragments(text, 4)
+	if len(frags) != 1 || frags[0] != `<em class="hltw1">𠮷野</em>の` {
+		t.Errorf("WildcardFragments => %v", frags)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// Kana folding for texts which rarely mark dakuten: voiced to unvoiced,
+// katakana to hiragana, small to large and ゐ/ゑ to い/え. A character is
+// folded to a character of the same UTF-8 length, so the offsets in the
+// folded text are those in the original.
+
+// subfield of text searched with the kana folded
+const kanaField = "text.kana"
+
+var kanaFolds = map[rune]rune{}
+
+func init() {
+	pairs := []struct{ from, to string }{
+		{"がぎぐげござじずぜぞだぢづでどばびぶべぼぱぴぷぺぽゔ",
+			"かきくけこさしすせそたちつてとはひふへほはひふへほう"},
+		{"ぁぃぅぇぉっゃゅょゎゕゖ", "あいうえおつやゆよわかけ"},
+		{"ゐゑ", "いえ"},
+		// iteration marks
+		{"ゞヽヾ", "ゝゝゝ"},
+		{"ヷヸヹヺ", "わいえを"},
+	}
+	for _, p := range pairs {
+		to := []rune(p.to)
+		for i, r := range []rune(p.from) {
+			kanaFolds[r] = to[i]
+		}
+	}
+}
+
+// ToHiragana converts the katakana of s to hiragana
+func ToHiragana(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'ァ' && r <= 'ヶ' || r == 'ヽ' || r == 'ヾ' {
+			return r - ('ァ' - 'ぁ')
+		}
+		return r
+	}, s)
+}
+
+// FoldKanaRune folds r
+func FoldKanaRune(r rune) rune {
+	// katakana to hiragana
+	if r >= 'ァ' && r <= 'ヶ' {
+		r -= 'ァ' - 'ぁ'
+	}
+	if f, ok := kanaFolds[r]; ok {
+		return f
+	}
+	return r
+}
+
+// FoldKana folds the kana of s
+func FoldKana(s string) string {
+	return strings.Map(FoldKanaRune, s)
+}
+
+// kanaCharFilter returns the char filter which folds kana as FoldKana
+func kanaCharFilter() *types.MappingCharFilter {
+	cf := types.NewMappingCharFilter()
+	for r := 'ぁ'; r <= 'ヾ'; r++ {
+		if f := FoldKanaRune(r); f != r {
+			cf.Mappings = append(cf.Mappings, fmt.Sprintf("%c=>%c", r, f))
+		}
+	}
+	return cf
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestFoldKana(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"がかる", "かかる"},
+		{"カヽル", "かゝる"},
+		{"ゐなか", "いなか"},
+		{"ヱビス", "えひす"},
+		{"きよう", "きよう"},
+		{"きょう", "きよう"},
+		{"ぱっと", "はつと"},
+		{"給ふ漢字", "給ふ漢字"},
+	}
+	for _, tt := range tests {
+		got := FoldKana(tt.s)
+		if got != tt.want {
+			t.Errorf("FoldKana(%s) => %s, want %s", tt.s, got, tt.want)
+		}
+		if len(got) != len(tt.s) {
+			t.Errorf("FoldKana(%s): length %d, want %d", tt.s, len(got), len(tt.s))
+		}
+	}
+
+	cf := kanaCharFilter()
+	for _, m := range []string{"が=>か", "ア=>あ", "ゑ=>え", "ッ=>つ"} {
+		if !slices.Contains(cf.Mappings, m) {
+			t.Errorf("kanaCharFilter: %s missing", m)
+		}
+	}
+}
+
+func TestQueryNodeKana(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("がかる NOT ゐなか")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetKana()
+	if got := n.TextField(); got != kanaField {
+		t.Errorf("TextField => %s, want %s", got, kanaField)
+	}
+	raw, err := json.Marshal(n.ESQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"bool":{"must":[` +
+		`{"match_phrase":{"text.kana":{"query":"がかる"}}},` +
+		`{"bool":{"must_not":[{"match_phrase":{"text.kana":{"query":"ゐなか"}}}]}}]}}`
+	if string(raw) != want {
+		t.Errorf("ESQuery =>\n%s\nwant\n%s", raw, want)
+	}
+	if !n.MatchesFragment("をカカルに") {
+		t.Errorf("MatchesFragment => false, want true")
+	}
+
+	// highlighted in the original text
+	w, err := ParseQuery("か?る")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetKana()
+	frags := w.WildcardFragments("人ガヽルに", 50)
+	if len(frags) != 1 || frags[0] != `人<em class="hltw1">ガヽル</em>に` {
+		t.Errorf("WildcardFragments => %v", frags)
+	}
+}
+
+func TestToHiragana(t *testing.T) {
+	t.Parallel()
+
+	if got, want := ToHiragana("オモフ思ヽヴ"), "おもふ思ゝゔ"; got != want {
+		t.Errorf("ToHiragana => %s, want %s", got, want)
+	}
+}
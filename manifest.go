@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultManifestURL = "https://kokusho.nijl.ac.jp/biblio/{bid}/manifest"
+
+/* ManifestProvider */
+// ManifestProvider gets the raw IIIF manifest of a book
+type ManifestProvider interface {
+	// Fetch returns the manifest of bid and where it comes from
+	Fetch(bid string) ([]byte, string, error)
+}
+
+// URLManifestProvider fetches manifests from the URL made of Template,
+// in which "{bid}" is replaced
+type URLManifestProvider struct {
+	Template string
+}
+
+func (p *URLManifestProvider) Fetch(bid string) ([]byte, string, error) {
+	src := p.URL(bid)
+	data, err := manifestFetcher.Get(bid, src, true)
+	return data, src, err
+}
+
+// URL returns the manifest URL of bid
+func (p *URLManifestProvider) URL(bid string) string {
+	return strings.ReplaceAll(p.Template, "{bid}", url.PathEscape(bid))
+}
+
+// DirManifestProvider reads manifests from Dir/<bid>/manifest.json
+// or Dir/<bid>.json
+type DirManifestProvider struct {
+	Dir string
+}
+
+func (p *DirManifestProvider) Fetch(bid string) ([]byte, string, error) {
+	if !validBid(bid) {
+		return nil, "", fmt.Errorf("invalid bid: %q", bid)
+	}
+
+	var err error
+	for _, src := range []string{
+		filepath.Join(p.Dir, bid, "manifest.json"),
+		filepath.Join(p.Dir, bid+".json"),
+	} {
+		var data []byte
+		data, err = os.ReadFile(src)
+		if err == nil {
+			return data, src, nil
+		}
+	}
+	return nil, "", fmt.Errorf("manifest not found: %s: %s", bid, err)
+}
+
+// NewManifestProvider returns the provider configured by
+// ManifestProvider ("url" (default) or "dir")
+func NewManifestProvider() (ManifestProvider, error) {
+	switch cfg.ManifestProvider {
+	case "", "url":
+		t := cfg.ManifestURL
+		if t == "" {
+			t = defaultManifestURL
+		}
+		return &URLManifestProvider{Template: t}, nil
+	case "dir":
+		if cfg.ManifestDir == "" {
+			return nil, fmt.Errorf("ManifestDir not set")
+		}
+		return &DirManifestProvider{Dir: cfg.ManifestDir}, nil
+	default:
+		return nil, fmt.Errorf("unexpected ManifestProvider: %s", cfg.ManifestProvider)
+	}
+}
+
+var manifestProvider ManifestProvider
+
+/* ManifestSources */
+// ManifestSources restricts the manifest sources given by callers to the
+// files under Dir and the URLs of Hosts
+type ManifestSources struct {
+	// no local file if empty
+	Dir   string
+	Hosts []string
+}
+
+// none allowed until configured
+var manifestSources = &ManifestSources{}
+
+// NewManifestSources returns the sources allowed by cfg: the files under
+// ManifestDir and the URLs of the host of ManifestURL and ManifestHosts
+func NewManifestSources() *ManifestSources {
+	t := cfg.ManifestURL
+	if t == "" {
+		t = defaultManifestURL
+	}
+	ms := &ManifestSources{Dir: cfg.ManifestDir}
+	if u, err := url.Parse(strings.ReplaceAll(t, "{bid}", "bid")); err == nil && u.Host != "" {
+		ms.Hosts = append(ms.Hosts, u.Host)
+	}
+	ms.Hosts = append(ms.Hosts, cfg.ManifestHosts...)
+	return ms
+}
+
+// Check returns an error unless src is allowed
+func (ms *ManifestSources) Check(src string) error {
+	if isURL(src) {
+		u, err := url.Parse(src)
+		if err != nil {
+			return fmt.Errorf("invalid url: %s: %s", src, err)
+		}
+		for _, h := range ms.Hosts {
+			if strings.EqualFold(u.Host, h) {
+				return nil
+			}
+		}
+		return fmt.Errorf("manifest host not allowed: %s", u.Host)
+	}
+
+	if ms.Dir == "" {
+		return fmt.Errorf("local manifest not allowed: %s", src)
+	}
+	dir, err := realPath(ms.Dir)
+	if err != nil {
+		return fmt.Errorf("manifest dir: %s", err)
+	}
+	path, err := realPath(strings.TrimPrefix(src, "file://"))
+	if err != nil {
+		return fmt.Errorf("file reading failed: %s: %s", src, err)
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("local manifest not allowed: %s", src)
+	}
+	return nil
+}
+
+// realPath returns the absolute path of p with the symlinks resolved
+func realPath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(p)
+}
+
+// FetchManifest gets the manifest of bid from src (URL or local path)
+// if given and allowed by manifestSources, otherwise from the configured
+// provider; remote manifests are got from the cache if any
+func FetchManifest(bid, src string) ([]byte, string, error) {
+	return fetchManifest(bid, src, true)
+}
+
+// RefetchManifest is FetchManifest ignoring the cached manifests
+func RefetchManifest(bid, src string) ([]byte, string, error) {
+	return fetchManifest(bid, src, false)
+}
+
+func fetchManifest(bid, src string, useCache bool) ([]byte, string, error) {
+	if src == "" {
+		if manifestProvider == nil {
+			return nil, "", fmt.Errorf("no manifest provider")
+		}
+		up, ok := manifestProvider.(*URLManifestProvider)
+		if !ok {
+			return manifestProvider.Fetch(bid)
+		}
+		src = up.URL(bid)
+	} else if err := manifestSources.Check(src); err != nil {
+		return nil, src, err
+	}
+
+	if isURL(src) {
+		data, err := manifestFetcher.Get(bid, src, useCache)
+		return data, src, err
+	}
+
+	data, err := os.ReadFile(strings.TrimPrefix(src, "file://"))
+	if err != nil {
+		return nil, src, fmt.Errorf("file reading failed: %s: %s", src, err)
+	}
+	return data, src, nil
+}
+
+func isURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+func validBid(bid string) bool {
+	return bid != "" && bid != "." && bid != ".." && !strings.ContainsAny(bid, `/\`)
+}
+
+/* ManifestFetcher */
+// ManifestFetcher gets remote manifests with a timeout, bounded retries
+// with exponential backoff, and a limit of concurrent requests per host;
+// fetched manifests are stored in Cache if not nil
+type ManifestFetcher struct {
+	Client *http.Client
+	// retries after the first request
+	RetryNum int
+	// wait before the first retry, doubled for each retry
+	Backoff time.Duration
+	// concurrent requests per host; unlimited if 0
+	HostConnNum int
+	Cache       *ManifestCache
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+const (
+	defaultManifestTimeout     = 30 * time.Second
+	defaultManifestRetryNum    = 3
+	defaultManifestBackoff     = 1 * time.Second
+	defaultManifestHostConnNum = 4
+	maxManifestBackoff         = 30 * time.Second
+)
+
+var manifestFetcher = NewManifestFetcher()
+
+// NewManifestFetcher returns the fetcher configured by cfg (defaults
+// for zero or unset values, or if cfg is not loaded); ManifestRetryNum = 0
+// disables retries and ManifestHostConnNum = 0 the limit
+func NewManifestFetcher() *ManifestFetcher {
+	mf := &ManifestFetcher{
+		Client:      &http.Client{Timeout: defaultManifestTimeout},
+		RetryNum:    defaultManifestRetryNum,
+		Backoff:     defaultManifestBackoff,
+		HostConnNum: defaultManifestHostConnNum,
+	}
+	if cfg == nil {
+		return mf
+	}
+
+	if cfg.ManifestTimeoutSec > 0 {
+		mf.Client.Timeout = time.Duration(cfg.ManifestTimeoutSec) * time.Second
+	}
+	if cfg.ManifestRetryNum != nil {
+		mf.RetryNum = max(0, *cfg.ManifestRetryNum)
+	}
+	if cfg.ManifestBackoffMSec > 0 {
+		mf.Backoff = time.Duration(cfg.ManifestBackoffMSec) * time.Millisecond
+	}
+	if cfg.ManifestHostConnNum != nil {
+		mf.HostConnNum = max(0, *cfg.ManifestHostConnNum)
+	}
+	if cfg.ManifestCacheDir != "" {
+		mf.Cache = &ManifestCache{Dir: cfg.ManifestCacheDir}
+	}
+	return mf
+}
+
+// Get returns the manifest of bid at src, from the cache if useCache
+func (mf *ManifestFetcher) Get(bid, src string, useCache bool) ([]byte, error) {
+	if useCache && mf.Cache != nil {
+		if data, ok := mf.Cache.Get(bid, src); ok {
+			return data, nil
+		}
+	}
+
+	data, err := mf.fetch(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if mf.Cache != nil {
+		if err := mf.Cache.Put(bid, src, data); err != nil {
+			log.Printf("manifest cache: %s", err)
+		}
+	}
+	return data, nil
+}
+
+func (mf *ManifestFetcher) fetch(src string) ([]byte, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %s: %s", src, err)
+	}
+	var wait time.Duration
+	for i := 0; ; i++ {
+		// the slot is not held while waiting for a retry
+		release := mf.acquire(u.Host)
+		data, retryAfter, err := mf.fetchOnce(src)
+		release()
+		if err == nil {
+			return data, nil
+		}
+		if retryAfter < 0 || i >= mf.RetryNum {
+			return nil, err
+		}
+
+		wait = mf.Backoff << i
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		wait = min(wait, maxManifestBackoff)
+		log.Printf("manifest: %s; retry in %s", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// fetchOnce requests src once; retryAfter is negative if the error is
+// not worth retrying, otherwise the wait requested by the server if any
+func (mf *ManifestFetcher) fetchOnce(src string) (data []byte, retryAfter time.Duration, err error) {
+	resp, err := mf.Client.Get(src)
+	if err != nil {
+		return nil, 0, fmt.Errorf("http request failed: %s: %s", src, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		err := fmt.Errorf("http request failed: %s: %s", src, resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests &&
+			resp.StatusCode < http.StatusInternalServerError {
+			return nil, -1, err
+		}
+		if sec, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil {
+			retryAfter = time.Duration(sec) * time.Second
+		}
+		return nil, retryAfter, err
+	}
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("response reading failed: %s: %s", src, err)
+	}
+	return data, 0, nil
+}
+
+// acquire waits for a free connection slot of host and returns the
+// function to release it
+func (mf *ManifestFetcher) acquire(host string) func() {
+	if mf.HostConnNum <= 0 {
+		return func() {}
+	}
+
+	mf.mu.Lock()
+	if mf.hosts == nil {
+		mf.hosts = map[string]chan struct{}{}
+	}
+	sem, ok := mf.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, mf.HostConnNum)
+		mf.hosts[host] = sem
+	}
+	mf.mu.Unlock()
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+/* ManifestCache */
+// ManifestCache stores fetched manifests as Dir/<bid>.json
+type ManifestCache struct {
+	Dir string
+}
+
+/* ManifestCacheEntry */
+type ManifestCacheEntry struct {
+	Src      string          `json:"src"`
+	Fetched  time.Time       `json:"fetched"`
+	Manifest json.RawMessage `json:"manifest"`
+}
+
+func (c *ManifestCache) path(bid string) string {
+	return filepath.Join(c.Dir, bid+".json")
+}
+
+// Get returns the cached manifest of bid fetched from src
+func (c *ManifestCache) Get(bid, src string) ([]byte, bool) {
+	if !validBid(bid) {
+		return nil, false
+	}
+	raw, err := os.ReadFile(c.path(bid))
+	if err != nil {
+		return nil, false
+	}
+	var e ManifestCacheEntry
+	if err := json.Unmarshal(raw, &e); err != nil || e.Src != src {
+		return nil, false
+	}
+	return e.Manifest, true
+}
+
+// Put stores the manifest of bid fetched from src
+func (c *ManifestCache) Put(bid, src string, data []byte) error {
+	if !validBid(bid) {
+		return fmt.Errorf("invalid bid: %q", bid)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("not JSON: %s", src)
+	}
+	raw, err := json.Marshal(&ManifestCacheEntry{
+		Src:      src,
+		Fetched:  time.Now().UTC(),
+		Manifest: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	// write and rename not to leave a broken file
+	f, err := os.CreateTemp(c.Dir, bid+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(bid))
+}
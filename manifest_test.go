@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestDirManifestProvider(t *testing.T) {
+	t.Parallel()
+
+	p := &DirManifestProvider{Dir: manifestDir}
+
+	t.Run("found", func(t *testing.T) {
+		_, src, err := p.Fetch("200000001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(manifestDir, "200000001", "manifest.json"); src != want {
+			t.Errorf("src: got %s, want %s", src, want)
+		}
+	})
+
+	for _, bid := range []string{"", "..", "../manifest", "999999999"} {
+		bid := bid
+		t.Run("error/"+bid, func(t *testing.T) {
+			if _, _, err := p.Fetch(bid); err == nil {
+				t.Errorf("%q: error expected", bid)
+			}
+		})
+	}
+}
+
+func TestBookTextFetchMetadata(t *testing.T) {
+	// not parallel: sets manifestSources
+	orig := manifestSources
+	manifestSources = &ManifestSources{Dir: manifestDir}
+	defer func() { manifestSources = orig }()
+
+	src := filepath.Join(manifestDir, "200000001", "manifest.json")
+	bt := &BookText{Bid: "200000001"}
+	if err := bt.FetchMetadata(src); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &BookText{
+		Bid:   "200000001",
+		Label: "方丈記",
+		Metadata: []*LabelValue{
+			{Label: "著者", Value: "鴨長明"},
+			{Label: "刊年", Value: "寛永3"},
+		},
+		Biblio:      Biblio{"author": []string{"鴨長明"}, "year": []int{1626}},
+		Attribution: "Example Library",
+		License:     "https://creativecommons.org/licenses/by-sa/4.0/",
+		Images: []string{
+			"https://example.org/iiif/200000001/00001.tif",
+			"https://example.org/image/00002",
+		},
+		Canvases: []Canvas{{Width: 6000, Height: 4000}, {Width: 6100, Height: 4100}},
+		Manifest: src,
+	}
+	if diff := cmp.Diff(want, bt); diff != "" {
+		t.Errorf("FetchMetadata mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data string
+		want *IIIFManifest
+	}{
+		{
+			name: "v2/no sequences",
+			data: `{"@context": "http://iiif.io/api/presentation/2/context.json",
+				"label": [{"@value": "Hojoki", "@language": "en"},
+					{"@value": "方丈記", "@language": "ja"}],
+				"sequences": []}`,
+			want: &IIIFManifest{
+				Version:  2,
+				Label:    "方丈記",
+				Metadata: []*LabelValue{},
+				Images:   []string{},
+				Canvases: []Canvas{},
+			},
+		},
+		{
+			name: "v3/no items",
+			data: `{"@context": "http://iiif.io/api/presentation/3/context.json",
+				"label": {"none": ["方丈記"]}}`,
+			want: &IIIFManifest{
+				Version:  3,
+				Label:    "方丈記",
+				Metadata: []*LabelValue{},
+				Images:   []string{},
+				Canvases: []Canvas{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifest([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseManifest mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBookTextFetchMetadataV3(t *testing.T) {
+	t.Parallel()
+
+	p := &DirManifestProvider{Dir: manifestDir}
+	data, _, err := p.Fetch("200000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &BookText{Bid: "200000002"}
+	if err := bt.SetManifest(data); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &BookText{
+		Bid:   "200000002",
+		Label: "徒然草",
+		Metadata: []*LabelValue{
+			{Label: "著者", Value: "吉田兼好"},
+			{Label: "刊年", Value: "慶長18"},
+		},
+		Biblio:      Biblio{"author": []string{"吉田兼好"}, "year": []int{1613}},
+		Attribution: "Example Library",
+		License:     "http://creativecommons.org/licenses/by/4.0/",
+		Images: []string{
+			"https://example.org/iiif/image/200000002-0001",
+			"https://example.org/iiif/image/200000002-0002",
+		},
+		Canvases: []Canvas{{Width: 5000, Height: 3500}, {Width: 5100, Height: 3600}},
+	}
+	if diff := cmp.Diff(want, bt); diff != "" {
+		t.Errorf("SetManifest mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestFetcherRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		wantReqs int32
+	}{
+		{"ok", []int{200}, false, 1},
+		{"transient", []int{502, 503, 200}, false, 3},
+		{"too many requests", []int{429, 200}, false, 2},
+		{"retries exhausted", []int{500, 500, 500, 500}, true, 3},
+		{"not found", []int{404, 200}, true, 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var reqs int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&reqs, 1)
+				w.WriteHeader(tt.statuses[n-1])
+				fmt.Fprint(w, `{"label": "ok"}`)
+			}))
+			defer ts.Close()
+
+			mf := &ManifestFetcher{
+				Client:   ts.Client(),
+				RetryNum: 2,
+				Backoff:  time.Millisecond,
+			}
+			data, err := mf.Get("200000001", ts.URL, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get: err => %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && string(data) != `{"label": "ok"}` {
+				t.Errorf("Get => %s", data)
+			}
+			if reqs != tt.wantReqs {
+				t.Errorf("requests => %d, want %d", reqs, tt.wantReqs)
+			}
+		})
+	}
+}
+
+func TestManifestFetcherHostConnNum(t *testing.T) {
+	t.Parallel()
+
+	var cur, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		defer atomic.AddInt32(&cur, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+
+	mf := &ManifestFetcher{Client: ts.Client(), HostConnNum: 2}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := mf.Get(fmt.Sprint(i), ts.URL, false); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("concurrent requests => %d, want <= 2", peak)
+	}
+}
+
+func TestManifestFetcherCache(t *testing.T) {
+	t.Parallel()
+
+	var reqs int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&reqs, 1)
+		fmt.Fprintf(w, `{"label":"v%d"}`, n)
+	}))
+	defer ts.Close()
+
+	mf := &ManifestFetcher{
+		Client: ts.Client(),
+		Cache:  &ManifestCache{Dir: t.TempDir()},
+	}
+	get := func(useCache bool, src string) string {
+		t.Helper()
+		data, err := mf.Get("200000001", src, useCache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := get(true, ts.URL); got != `{"label":"v1"}` {
+		t.Errorf("first => %s", got)
+	}
+	// cached
+	if got := get(true, ts.URL); got != `{"label":"v1"}` {
+		t.Errorf("cached => %s", got)
+	}
+	// refetched and cached
+	if got := get(false, ts.URL); got != `{"label":"v2"}` {
+		t.Errorf("refetched => %s", got)
+	}
+	if got := get(true, ts.URL); got != `{"label":"v2"}` {
+		t.Errorf("cached after refetch => %s", got)
+	}
+	// another source of the same bid
+	if got := get(true, ts.URL+"/other"); got != `{"label":"v3"}` {
+		t.Errorf("other source => %s", got)
+	}
+	if reqs != 3 {
+		t.Errorf("requests => %d, want 3", reqs)
+	}
+}
+
+func TestManifestSources(t *testing.T) {
+	t.Parallel()
+
+	ms := &ManifestSources{Dir: manifestDir, Hosts: []string{"example.org"}}
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		{"https://example.org/iiif/200000001/manifest", true},
+		{"https://EXAMPLE.org/iiif/200000001/manifest", true},
+		{"http://127.0.0.1:9200/_cat/indices", false},
+		{"https://example.org.evil.test/manifest", false},
+		{filepath.Join(manifestDir, "200000001", "manifest.json"), true},
+		{"file://" + filepath.Join(manifestDir, "200000001", "manifest.json"), true},
+		{filepath.Join(manifestDir, "..", "..", "go.mod"), false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if err := ms.Check(tt.src); (err == nil) != tt.ok {
+			t.Errorf("Check(%s) => %v, want ok=%v", tt.src, err, tt.ok)
+		}
+	}
+
+	if err := (&ManifestSources{}).Check(filepath.Join(manifestDir, "200000001.json")); err == nil {
+		t.Errorf("Check without Dir => no error")
+	}
+}
+
+func TestNewManifestFetcherConfig(t *testing.T) {
+	// not parallel: sets cfg
+	orig := cfg
+	defer func() { cfg = orig }()
+
+	cfg = &Config{}
+	mf := NewManifestFetcher()
+	if mf.RetryNum != defaultManifestRetryNum || mf.HostConnNum != defaultManifestHostConnNum {
+		t.Errorf("unset: RetryNum, HostConnNum => %d, %d", mf.RetryNum, mf.HostConnNum)
+	}
+
+	cfg = &Config{ManifestRetryNum: Int2Pt(0), ManifestHostConnNum: Int2Pt(0)}
+	mf = NewManifestFetcher()
+	if mf.RetryNum != 0 || mf.HostConnNum != 0 {
+		t.Errorf("0: RetryNum, HostConnNum => %d, %d, want 0, 0", mf.RetryNum, mf.HostConnNum)
+	}
+}
+
+func TestManifestFetcherBackoffReleasesSlot(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failing" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+
+	mf := &ManifestFetcher{
+		Client:      ts.Client(),
+		RetryNum:    1,
+		Backoff:     time.Second,
+		HostConnNum: 1,
+	}
+	failed := make(chan error)
+	go func() {
+		_, err := mf.Get("1", ts.URL+"/failing", false)
+		failed <- err
+	}()
+	// let the first request fail and back off
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := mf.Get("2", ts.URL+"/ok", false); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Get waited %s for the backoff of another request", d)
+	}
+	if err := <-failed; err == nil {
+		t.Errorf("Get of /failing => no error")
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+const (
+	BiblioKeyword = "keyword"
+	BiblioYear    = "year"
+)
+
+/* MetadataField */
+// MetadataField maps manifest metadata labels to a typed field of
+// BookText.Biblio
+type MetadataField struct {
+	Name   string
+	Labels []string
+	// "keyword" (default) or "year"
+	Type string
+}
+
+// used unless MetadataFields is configured
+var defaultMetadataFields = []MetadataField{
+	{Name: "author", Labels: []string{"著者", "作者", "編者"}, Type: BiblioKeyword},
+	{Name: "year", Labels: []string{"刊年", "書写年", "成立年"}, Type: BiblioYear},
+	{Name: "genre", Labels: []string{"分類"}, Type: BiblioKeyword},
+}
+
+// MetadataFields returns the configured metadata fields
+func MetadataFields() []MetadataField {
+	if cfg == nil || len(cfg.MetadataFields) == 0 {
+		return defaultMetadataFields
+	}
+	return cfg.MetadataFields
+}
+
+// CheckMetadataFields validates the configured metadata fields
+func CheckMetadataFields() error {
+	names := map[string]bool{}
+	for _, f := range MetadataFields() {
+		if f.Name == "" || strings.ContainsAny(f.Name, ".*, ") {
+			return fmt.Errorf("invalid metadata field name: %q", f.Name)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("duplicated metadata field name: %s", f.Name)
+		}
+		names[f.Name] = true
+		switch f.Type {
+		case "", BiblioKeyword, BiblioYear:
+		default:
+			return fmt.Errorf("unexpected metadata field type: %s: %s", f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+/* Biblio */
+// Biblio holds the values of the metadata fields: []string for keyword
+// fields and []int for year fields
+type Biblio map[string]any
+
+// NewBiblio extracts the metadata fields from md; nil if none found
+func NewBiblio(md []*LabelValue) Biblio {
+	b := Biblio{}
+	for _, f := range MetadataFields() {
+		var (
+			kws   []string
+			years []int
+		)
+		for _, lv := range md {
+			if lv == nil || !slices.Contains(f.Labels, lv.Label) {
+				continue
+			}
+			if f.Type == BiblioYear {
+				if y, ok := ParseYear(lv.Value); ok && !slices.Contains(years, y) {
+					years = append(years, y)
+				}
+				continue
+			}
+			v := strings.TrimSpace(lv.Value)
+			if v != "" && !slices.Contains(kws, v) {
+				kws = append(kws, v)
+			}
+		}
+		if len(kws) > 0 {
+			b[f.Name] = kws
+		}
+		if len(years) > 0 {
+			b[f.Name] = years
+		}
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// BiblioProperty returns the ES mapping of Biblio, an object of the
+// fields rather than the top level, where the names configured could clash
+// with those of BookText
+func BiblioProperty() *types.ObjectProperty {
+	prop := types.NewObjectProperty()
+	prop.Properties = map[string]types.Property{}
+	for _, f := range MetadataFields() {
+		if f.Type == BiblioYear {
+			prop.Properties[f.Name] = types.NewIntegerNumberProperty()
+		} else {
+			prop.Properties[f.Name] = types.NewKeywordProperty()
+		}
+	}
+	return prop
+}
+
+/* era years */
+
+// start years of the eras from 延暦 (782); 元亨 as 元享 by yearReplacer
+var eraStartYears = map[string]int{
+	// Heian
+	"延暦": 782, "大同": 806, "弘仁": 810, "天長": 824, "承和": 834,
+	"嘉祥": 848, "仁寿": 851, "斉衡": 854, "天安": 857, "貞観": 859,
+	"元慶": 877, "仁和": 885, "寛平": 889, "昌泰": 898, "延喜": 901,
+	"延長": 923, "承平": 931, "天慶": 938, "天暦": 947, "天徳": 957,
+	"応和": 961, "康保": 964, "安和": 968, "天禄": 970, "天延": 973,
+	"貞元": 976, "天元": 978, "永観": 983, "寛和": 985, "永延": 987,
+	"永祚": 989, "正暦": 990, "長徳": 995, "長保": 999, "寛弘": 1004,
+	"長和": 1012, "寛仁": 1017, "治安": 1021, "万寿": 1024, "長元": 1028,
+	"長暦": 1037, "長久": 1040, "寛徳": 1044, "永承": 1046, "天喜": 1053,
+	"康平": 1058, "治暦": 1065, "延久": 1069, "承保": 1074, "承暦": 1077,
+	"永保": 1081, "応徳": 1084, "寛治": 1087, "嘉保": 1094, "永長": 1096,
+	"承徳": 1097, "康和": 1099, "長治": 1104, "嘉承": 1106, "天仁": 1108,
+	"天永": 1110, "永久": 1113, "元永": 1118, "保安": 1120, "天治": 1124,
+	"大治": 1126, "天承": 1131, "長承": 1132, "保延": 1135, "永治": 1141,
+	"康治": 1142, "天養": 1144, "久安": 1145, "仁平": 1151, "久寿": 1154,
+	"保元": 1156, "平治": 1159, "永暦": 1160, "応保": 1161, "長寛": 1163,
+	"永万": 1165, "仁安": 1166, "嘉応": 1169, "承安": 1171, "安元": 1175,
+	"治承": 1177, "養和": 1181, "寿永": 1182, "元暦": 1184,
+	// Kamakura
+	"文治": 1185, "建久": 1190, "正治": 1199, "建仁": 1201, "元久": 1204,
+	"建永": 1206, "承元": 1207, "建暦": 1211, "建保": 1213, "承久": 1219,
+	"貞応": 1222, "元仁": 1224, "嘉禄": 1225, "安貞": 1227, "寛喜": 1229,
+	"貞永": 1232, "天福": 1233, "文暦": 1234, "嘉禎": 1235, "暦仁": 1238,
+	"延応": 1239, "仁治": 1240, "寛元": 1243, "宝治": 1247, "建長": 1249,
+	"康元": 1256, "正嘉": 1257, "正元": 1259, "文応": 1260, "弘長": 1261,
+	"文永": 1264, "建治": 1275, "弘安": 1278, "正応": 1288, "永仁": 1293,
+	"正安": 1299, "乾元": 1302, "嘉元": 1303, "徳治": 1306, "延慶": 1308,
+	"応長": 1311, "正和": 1312, "文保": 1317, "元応": 1319, "元享": 1321,
+	"正中": 1324, "嘉暦": 1326, "元徳": 1329, "元弘": 1331, "正慶": 1332,
+	"建武": 1334,
+	// Southern Court
+	"延元": 1336, "興国": 1340, "正平": 1346, "建徳": 1370, "文中": 1372,
+	"天授": 1375, "弘和": 1381, "元中": 1384,
+	// Northern Court and Muromachi
+	"暦応": 1338, "康永": 1342, "貞和": 1345, "観応": 1350, "文和": 1352,
+	"延文": 1356, "康安": 1361, "貞治": 1362, "応安": 1368, "永和": 1375,
+	"康暦": 1379, "永徳": 1381, "至徳": 1384, "嘉慶": 1387, "康応": 1389,
+	"明徳": 1390, "応永": 1394, "正長": 1428, "永享": 1429, "嘉吉": 1441,
+	"文安": 1444, "宝徳": 1449, "享徳": 1452, "康正": 1455, "長禄": 1457,
+	"寛正": 1460, "文正": 1466,
+	// from 応仁
+	"応仁": 1467, "文明": 1469, "長享": 1487, "延徳": 1489, "明応": 1492,
+	"文亀": 1501, "永正": 1504, "大永": 1521, "享禄": 1528, "天文": 1532,
+	"弘治": 1555, "永禄": 1558, "元亀": 1570, "天正": 1573, "文禄": 1592,
+	"慶長": 1596, "元和": 1615, "寛永": 1624, "正保": 1644, "慶安": 1648,
+	"承応": 1652, "明暦": 1655, "万治": 1658, "寛文": 1661, "延宝": 1673,
+	"天和": 1681, "貞享": 1684, "元禄": 1688, "宝永": 1704, "正徳": 1711,
+	"享保": 1716, "元文": 1736, "寛保": 1741, "延享": 1744, "寛延": 1748,
+	"宝暦": 1751, "明和": 1764, "安永": 1772, "天明": 1781, "寛政": 1789,
+	"享和": 1801, "文化": 1804, "文政": 1818, "天保": 1830, "弘化": 1844,
+	"嘉永": 1848, "安政": 1854, "万延": 1860, "文久": 1861, "元治": 1864,
+	"慶応": 1865, "明治": 1868, "大正": 1912, "昭和": 1926, "平成": 1989,
+	"令和": 2019,
+}
+
+// old forms in era names and full-width digits
+var yearReplacer = strings.NewReplacer(
+	"寶", "宝", "寳", "宝", "萬", "万", "應", "応", "德", "徳", "龜", "亀", "祿", "禄",
+	"曆", "暦", "亨", "享", "壽", "寿", "觀", "観", "齊", "斉",
+	"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+	"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+)
+
+// kanji digits of years, e.g. 一六二六
+var kanjiDigits = map[rune]rune{
+	'〇': '0', '○': '0', '一': '1', '二': '2', '三': '3', '四': '4',
+	'五': '5', '六': '6', '七': '7', '八': '8', '九': '9',
+}
+
+var (
+	kanjiYearRe   = regexp.MustCompile(`[〇○一二三四五六七八九]{4,}`)
+	westernYearRe = regexp.MustCompile(`(^|[^0-9])(1[0-9]{3}|20[0-9]{2})($|[^0-9])`)
+	eraYearRe     = regexp.MustCompile(`(\p{Han}{2})\s*(元|[0-9]+|[〇一二三四五六七八九十廿卅]+)\s*年?`)
+)
+
+// ParseYear returns the (first) year in s, e.g. "1626", "一六二六",
+// "寛永3", "寛永三年", "寛永3 [1626]" => 1626
+func ParseYear(s string) (int, bool) {
+	s = yearReplacer.Replace(s)
+	// four kanji digits as a western year
+	s = kanjiYearRe.ReplaceAllStringFunc(s, func(m string) string {
+		if utf8.RuneCountInString(m) != 4 {
+			return m
+		}
+		return strings.Map(func(r rune) rune { return kanjiDigits[r] }, m)
+	})
+
+	if m := westernYearRe.FindStringSubmatch(s); m != nil {
+		y, _ := strconv.Atoi(m[2])
+		return y, true
+	}
+
+	for _, m := range eraYearRe.FindAllStringSubmatch(s, -1) {
+		start, ok := eraStartYears[m[1]]
+		if !ok {
+			continue
+		}
+		n, ok := parseEraNumber(m[2])
+		if !ok || n <= 0 {
+			continue
+		}
+		return start + n - 1, true
+	}
+	return 0, false
+}
+
+// parseEraNumber parses "元", arabic or kanji numbers up to 99
+func parseEraNumber(s string) (int, bool) {
+	if s == "元" {
+		return 1, true
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+
+	digits := map[rune]int{
+		'〇': 0, '一': 1, '二': 2, '三': 3, '四': 4,
+		'五': 5, '六': 6, '七': 7, '八': 8, '九': 9,
+	}
+	n, cur := 0, -1
+	for _, r := range s {
+		switch r {
+		case '十':
+			if cur == -1 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = -1
+		case '廿':
+			n += 20
+		case '卅':
+			n += 30
+		default:
+			d, ok := digits[r]
+			if !ok {
+				return 0, false
+			}
+			if cur == -1 {
+				cur = d
+			} else {
+				// e.g. "一五"
+				cur = cur*10 + d
+			}
+		}
+	}
+	if cur != -1 {
+		n += cur
+	}
+	return n, true
+}
+
+/* search filters */
+
+/* BiblioRange */
+type BiblioRange struct {
+	From *int
+	To   *int
+}
+
+// BindBiblioFilters reads "<name>" (comma separated values) and, for year
+// fields, "<name>From" and "<name>To", e.g. "author=...&yearFrom=1600"
+func (sp *TextSearchParam) BindBiblioFilters(qs url.Values) error {
+	for _, f := range MetadataFields() {
+		if f.Type == BiblioYear {
+			var r BiblioRange
+			for _, p := range []struct {
+				key string
+				v   **int
+			}{
+				{f.Name + "From", &r.From},
+				{f.Name + "To", &r.To},
+			} {
+				s := qs.Get(p.key)
+				if s == "" {
+					continue
+				}
+				y, err := strconv.Atoi(s)
+				if err != nil {
+					return fmt.Errorf("%s: integer expected: %s", p.key, s)
+				}
+				*p.v = &y
+			}
+			if r.From != nil || r.To != nil {
+				if sp.BiblioRanges == nil {
+					sp.BiblioRanges = map[string]BiblioRange{}
+				}
+				sp.BiblioRanges[f.Name] = r
+			}
+		}
+
+		vals := []string{}
+		for _, key := range []string{f.Name, f.Name + "[]"} {
+			for _, v := range qs[key] {
+				for _, s := range strings.Split(v, ",") {
+					if s == "" {
+						continue
+					}
+					if _, err := strconv.Atoi(s); err != nil && f.Type == BiblioYear {
+						return fmt.Errorf("%s: integer expected: %s", key, s)
+					}
+					vals = append(vals, s)
+				}
+			}
+		}
+		if len(vals) > 0 {
+			if sp.Biblio == nil {
+				sp.Biblio = map[string][]string{}
+			}
+			sp.Biblio[f.Name] = vals
+		}
+	}
+	return nil
+}
+
+// biblioCacheKey returns the part of the cache key for the filters
+func (sp *TextSearchParam) biblioCacheKey() string {
+	s := ""
+	for _, f := range MetadataFields() {
+		if vals, ok := sp.Biblio[f.Name]; ok {
+			v := append([]string{}, vals...)
+			slices.Sort(v)
+			s += "&" + f.Name + "=" + strings.Join(v, ",")
+		}
+		if r, ok := sp.BiblioRanges[f.Name]; ok {
+			if r.From != nil {
+				s += fmt.Sprintf("&%sFrom=%d", f.Name, *r.From)
+			}
+			if r.To != nil {
+				s += fmt.Sprintf("&%sTo=%d", f.Name, *r.To)
+			}
+		}
+	}
+	return s
+}
+
+// biblioQueries returns the filter queries
+func (sp *TextSearchParam) biblioQueries() []types.Query {
+	qs := []types.Query{}
+	for _, f := range MetadataFields() {
+		field := "biblio." + f.Name
+		if vals, ok := sp.Biblio[f.Name]; ok {
+			qs = append(qs, types.Query{
+				Terms: &types.TermsQuery{
+					TermsQuery: map[string]types.TermsQueryField{
+						field: vals,
+					},
+				},
+			})
+		}
+		if r, ok := sp.BiblioRanges[f.Name]; ok {
+			nr := types.NumberRangeQuery{}
+			if r.From != nil {
+				v := types.Float64(*r.From)
+				nr.Gte = &v
+			}
+			if r.To != nil {
+				v := types.Float64(*r.To)
+				nr.Lte = &v
+			}
+			qs = append(qs, types.Query{
+				Range: map[string]types.RangeQuery{
+					field: nr,
+				},
+			})
+		}
+	}
+	return qs
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestParseYear(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		s    string
+		want int
+		ok   bool
+	}{
+		{"1626", 1626, true},
+		{"寛永3", 1626, true},
+		{"寛永三年", 1626, true},
+		{"寛永３年刊", 1626, true},
+		{"寛永3 [1626]", 1626, true},
+		{"元禄元年", 1688, true},
+		{"寳暦十二年", 1762, true},
+		{"天保廿三年", 1852, true},
+		{"明治二十年", 1887, true},
+		{"慶應4", 1868, true},
+		{"江戸時代寛文五年写", 1665, true},
+		{"一六二六", 1626, true},
+		{"一六二六年刊", 1626, true},
+		{"寛永三年（一六二六）", 1626, true},
+		{"元禄一五", 1702, true},
+		{"文永十一年写", 1274, true},
+		{"永仁五年", 1297, true},
+		{"寛喜2", 1230, true},
+		{"延暦元年", 782, true},
+		{"元亨元年", 1321, true},
+		{"正平七年", 1352, true},
+		{"一二三", 0, false},
+		{"江戸後期", 0, false},
+		{"写", 0, false},
+	}
+
+	for _, tt := range tests {
+		y, ok := ParseYear(tt.s)
+		if y != tt.want || ok != tt.ok {
+			t.Errorf("ParseYear(%s) => %d, %t, want %d, %t", tt.s, y, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNewBiblio(t *testing.T) {
+	t.Parallel()
+
+	got := NewBiblio([]*LabelValue{
+		{Label: "書名", Value: "方丈記"},
+		{Label: "著者", Value: "鴨長明"},
+		{Label: "編者", Value: "鴨長明"},
+		{Label: "刊年", Value: "寛永3"},
+		{Label: "成立年", Value: "建暦2年"},
+	})
+	want := Biblio{
+		"author": []string{"鴨長明"},
+		"year":   []int{1626, 1212},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NewBiblio mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := NewBiblio([]*LabelValue{{Label: "書名", Value: "方丈記"}}); got != nil {
+		t.Errorf("NewBiblio => %v, want nil", got)
+	}
+}
+
+func TestBindBiblioFilters(t *testing.T) {
+	t.Parallel()
+
+	var sp TextSearchParam
+	qs, _ := url.ParseQuery("author=鴨長明,吉田兼好&yearFrom=1600&yearTo=1699")
+	if err := sp.BindBiblioFilters(qs); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string][]string{"author": {"鴨長明", "吉田兼好"}}, sp.Biblio); diff != "" {
+		t.Errorf("Biblio mismatch (-want +got):\n%s", diff)
+	}
+	r := sp.BiblioRanges["year"]
+	if r.From == nil || *r.From != 1600 || r.To == nil || *r.To != 1699 {
+		t.Errorf("BiblioRanges[year] => %v", r)
+	}
+	if got, want := sp.biblioCacheKey(), "&author=吉田兼好,鴨長明&yearFrom=1600&yearTo=1699"; got != want {
+		t.Errorf("biblioCacheKey => %s, want %s", got, want)
+	}
+	if n := len(sp.biblioQueries()); n != 2 {
+		t.Errorf("biblioQueries => %d queries, want 2", n)
+	}
+
+	// a year facet value
+	sp = TextSearchParam{}
+	qs, _ = url.ParseQuery("year=1626")
+	if err := sp.BindBiblioFilters(qs); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string][]string{"year": {"1626"}}, sp.Biblio); diff != "" {
+		t.Errorf("Biblio mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, q := range []string{"yearFrom=寛永", "year=寛永3"} {
+		qs, _ = url.ParseQuery(q)
+		if err := (&TextSearchParam{}).BindBiblioFilters(qs); err == nil {
+			t.Errorf("%s: error expected", q)
+		}
+	}
+}
@@ -1,31 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"math"
 	"slices"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
-/* IIIFManifestMetadata */
-type IIIFManifestMetadata struct {
-	Label       string        `json:"label"`
-	Metadata    []*LabelValue `json:"metadata"`
-	Attribution string        `json:"attribution"`
-	License     string        `json:"license"`
-	Sequences   []struct {
-		Canvases []struct {
-			Images []struct {
-				Resource struct {
-					ID string `json:"@id"`
-				} `json:"resource"`
-			} `json:"images"`
-		} `json:"canvases"`
-	} `json:"sequences"`
-}
-
 /* LabelValue */
 type LabelValue struct {
 	Label string `json:"label"`
@@ -38,6 +21,31 @@ type BB struct {
 	Y      int `json:"y"`
 	Width  int `json:"w"`
 	Height int `json:"h"`
+	// line confidence in [0, 1]; 0 if not given by OCR
+	Confidence float64 `json:"conf,omitempty"`
+}
+
+/* Seg */
+// Seg is a sub-line (word or character) box given by OCR
+type Seg struct {
+	Pos int `json:"p"` // offset in BookText.Text (runes)
+	Len int `json:"l"` // length (runes)
+	BB
+}
+
+/* OCRImage */
+// OCRImage is the image given to OCR
+type OCRImage struct {
+	Name   string `json:"name,omitempty"`
+	Width  int    `json:"w"`
+	Height int    `json:"h"`
+}
+
+/* Canvas */
+// Canvas is a canvas of the IIIF manifest
+type Canvas struct {
+	Width  int `json:"w"`
+	Height int `json:"h"`
 }
 
 /* BookText */
@@ -47,19 +55,40 @@ type BookText struct {
 	ELevel ELevel   `json:"elevel"`
 	Tags   []string `json:"tags"`
 	// derived from IIIF manifest
-	Label       string        `json:"label"`
-	Metadata    []*LabelValue `json:"metadata"`
-	Attribution string        `json:"attribution"`
-	License     string        `json:"license"`
-	Images      []string      `json:"images"`
+	Label    string        `json:"label"`
+	Metadata []*LabelValue `json:"metadata"`
+	// typed values of metadata; see MetadataField
+	Biblio      Biblio   `json:"biblio,omitempty"`
+	Attribution string   `json:"attribution"`
+	License     string   `json:"license"`
+	Images      []string `json:"images"`
+	Canvases    []Canvas `json:"canvases,omitempty"`
+	Manifest    string   `json:"manifest,omitempty"`
+	// canvas index (0-origin) of each OCR page; -1 if not mapped
+	PageCanvases []int `json:"pageCanvases,omitempty"`
+	// method of PageCanvases; see AlignPages
+	PageAlignment string `json:"pageAlignment,omitempty"`
 	// derived from OCR
 	Text string `json:"text"`
 	Pbs  []int  `json:"pbs"`
 	Lbs  []int  `json:"lbs"`
 	BBs  []*BB  `json:"bbs"`
+	Segs []*Seg `json:"segs,omitempty"`
+	// one per page; zero if unknown
+	OCRImages []OCRImage `json:"ocrImages,omitempty"`
 	// derived from MeCab
 	MecabType string   `json:"mecabType"`
 	Mecabed   []string `json:"mecabed"`
+	// [offset, length] (runes) in Text of the tokens of Mecabed
+	MecabSpans [][2]int `json:"mecabSpans,omitempty"`
+	// Mecabed of the text with the iteration marks expanded, and the
+	// spans in Text; empty if the text has none
+	MecabedExpanded    []string `json:"mecabedExpanded,omitempty"`
+	MecabExpandedSpans [][2]int `json:"mecabExpandedSpans,omitempty"`
+	// readings (hiragana) of the tokens of Mecabed joined, and the spans
+	// of the tokens in it
+	Reading      string   `json:"reading,omitempty"`
+	ReadingSpans [][2]int `json:"readingSpans,omitempty"`
 }
 
 /* BookMetadata */
@@ -70,6 +99,7 @@ type BookMetadata struct {
 	Tags        []string      `json:"tags"`
 	Label       string        `json:"label"`
 	Metadata    []*LabelValue `json:"metadata"`
+	Biblio      Biblio        `json:"biblio,omitempty"`
 	Attribution string        `json:"attribution"`
 	License     string        `json:"license"`
 }
@@ -80,20 +110,15 @@ func NewBookText(rp *RegisterParam) (bt *BookText, err error) {
 		return nil, err
 	}
 
-	switch rp.Type {
-	case "ndlocrv1":
-		bt, err = NdlOcrV12BookText(ois)
-	case "ndlocrv2":
-		bt, err = NdlOcrV22BookText(ois, false)
-	case "ndlocrv2detail":
-		bt, err = NdlOcrV22BookText(ois, true)
-	case "ndlocrv3":
-		bt, err = NdlOcrV32BookText(ois, false)
-	case "ndlocrv3detail":
-		bt, err = NdlOcrV32BookText(ois, true)
-	default:
-		return nil, fmt.Errorf("wrong type: %s", rp.Type)
+	if rp.Type == OCRFormatAuto {
+		f, err := DetectOCRFormat(ois)
+		if err != nil {
+			return nil, err
+		}
+		rp.Type = f.Name
 	}
+
+	bt, err = ConvertOCR(rp.Type, ois)
 	if err != nil {
 		return nil, err
 	}
@@ -123,40 +148,42 @@ func (bt *BookText) GetMetadata() *BookMetadata {
 		Tags:        bt.Tags,
 		Label:       bt.Label,
 		Metadata:    bt.Metadata,
+		Biblio:      bt.Biblio,
 		Attribution: bt.Attribution,
 		License:     bt.License,
 	}
 }
 
-func (bt *BookText) FetchKokushoMetadata() error {
-	url := "https://kokusho.nijl.ac.jp/biblio/" + bt.Bid + "/manifest"
-	resp, err := http.Get(url)
+// FetchMetadata sets metadata from the IIIF manifest got from src
+// (URL or local path) if given, otherwise from the configured provider
+func (bt *BookText) FetchMetadata(src string) error {
+	data, src, err := FetchManifest(bt.Bid, src)
 	if err != nil {
-		return fmt.Errorf("http request failed: %s: %s", url, err)
+		return err
 	}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("response reading failed: %s: %s", url, err)
+	if err := bt.SetManifest(data); err != nil {
+		return fmt.Errorf("%s: %s", src, err)
 	}
+	bt.Manifest = src
 
-	var m IIIFManifestMetadata
-	if err := json.Unmarshal(data, &m); err != nil {
-		return fmt.Errorf("response parsing failed: %s: %s", url, err)
+	return nil
+}
+
+// SetManifest sets metadata from the raw IIIF manifest (v2 or v3)
+func (bt *BookText) SetManifest(data []byte) error {
+	m, err := ParseManifest(data)
+	if err != nil {
+		return err
 	}
 
 	bt.Label = m.Label
 	bt.Metadata = m.Metadata
+	bt.Biblio = NewBiblio(m.Metadata)
 	bt.Attribution = m.Attribution
 	bt.License = m.License
-
-	canvases := m.Sequences[0].Canvases
-	bt.Images = make([]string, len(canvases))
-	for i := 0; i < len(canvases); i++ {
-		id := canvases[i].Images[0].Resource.ID
-		idx := strings.Index(id, ".tif/")
-		bt.Images[i] = id[0 : idx+4]
-	}
+	bt.Images = m.Images
+	bt.Canvases = m.Canvases
 
 	return nil
 }
@@ -166,13 +193,24 @@ func (bt *BookText) SetMecabType(mecabType string) error {
 		return nil
 	}
 
-	keys, err := MecabFilter(mecabType, bt.Text)
+	mt, err := MecabFilter(mecabType, bt.Text)
 	if err != nil {
 		return err
 	}
 
 	bt.MecabType = mecabType
-	bt.Mecabed = keys
+	bt.Mecabed = mt.Keys
+	bt.MecabSpans = mt.Spans
+	bt.Reading, bt.ReadingSpans = mt.Reading()
+
+	if text, src := ExpandOdoriji(bt.Text); text != bt.Text {
+		mt, err := MecabFilter(mecabType, text)
+		if err != nil {
+			return err
+		}
+		bt.MecabedExpanded = mt.Keys
+		bt.MecabExpandedSpans = mapSpans(bt.Text, text, src, mt.Spans)
+	}
 	return nil
 }
 
@@ -191,3 +229,100 @@ func (bt *BookText) GetText(page, line int) string {
 	endPos := bt.Lbs[lidx+1]
 	return string([]rune(bt.Text)[startPos:endPos])
 }
+
+// Scale returns a copy of bb scaled by sx and sy
+func (bb *BB) Scale(sx, sy float64) *BB {
+	return &BB{
+		X:          int(math.Round(float64(bb.X) * sx)),
+		Y:          int(math.Round(float64(bb.Y) * sy)),
+		Width:      int(math.Round(float64(bb.Width) * sx)),
+		Height:     int(math.Round(float64(bb.Height) * sy)),
+		Confidence: bb.Confidence,
+	}
+}
+
+// CanvasScale returns the factors to scale coordinates on the OCR image
+// of the page (0-origin) to the canvas; ok is false if sizes are unknown
+func (bt *BookText) CanvasScale(page int) (sx, sy float64, ok bool) {
+	c := bt.PageCanvas(page)
+	if page < 0 || page >= len(bt.OCRImages) || c < 0 || c >= len(bt.Canvases) {
+		return 0, 0, false
+	}
+	img, cv := bt.OCRImages[page], bt.Canvases[c]
+	if img.Width == 0 || img.Height == 0 || cv.Width == 0 || cv.Height == 0 {
+		return 0, 0, false
+	}
+	return float64(cv.Width) / float64(img.Width),
+		float64(cv.Height) / float64(img.Height), true
+}
+
+// IsVertical reports whether the box is of a vertical line
+func (bb *BB) IsVertical() bool {
+	return bb.Height > bb.Width
+}
+
+// Slice estimates the box of the characters [from, to) of n characters
+// laid evenly in bb along its longer side
+func (bb *BB) Slice(from, to, n int) *BB {
+	if n <= 0 || (from <= 0 && to >= n) {
+		return &BB{X: bb.X, Y: bb.Y, Width: bb.Width, Height: bb.Height}
+	}
+	if bb.IsVertical() {
+		y := bb.Y + bb.Height*from/n
+		return &BB{X: bb.X, Y: y, Width: bb.Width, Height: bb.Y + bb.Height*to/n - y}
+	}
+	x := bb.X + bb.Width*from/n
+	return &BB{X: x, Y: bb.Y, Width: bb.X + bb.Width*to/n - x, Height: bb.Height}
+}
+
+// Union returns the box containing both bb and o
+func (bb *BB) Union(o *BB) *BB {
+	if bb == nil {
+		return o
+	}
+	x0, y0 := min(bb.X, o.X), min(bb.Y, o.Y)
+	x1 := max(bb.X+bb.Width, o.X+o.Width)
+	y1 := max(bb.Y+bb.Height, o.Y+o.Height)
+	return &BB{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// SpanBBs returns tight boxes, one per line, of the text [pos, pos+n)
+// (runes) with the line indices; segs are used if any, otherwise boxes
+// are estimated from the line boxes
+func (bt *BookText) SpanBBs(pos, n int) ([]*BB, []int) {
+	bbs := []*BB{}
+	lidxs := []int{}
+	end := pos + n
+	textLen := utf8.RuneCountInString(bt.Text)
+
+	lidx := sort.Search(len(bt.Lbs),
+		func(i int) bool { return bt.Lbs[i] > pos }) - 1
+	for ; lidx >= 0 && lidx < len(bt.Lbs) && bt.Lbs[lidx] < end; lidx++ {
+		ls := bt.Lbs[lidx]
+		le := textLen
+		if lidx+1 < len(bt.Lbs) {
+			le = bt.Lbs[lidx+1]
+		}
+		a, b := max(pos, ls), min(end, le)
+		if a >= b || lidx >= len(bt.BBs) {
+			continue
+		}
+
+		var bb *BB
+		sidx := sort.Search(len(bt.Segs),
+			func(i int) bool { return bt.Segs[i].Pos+bt.Segs[i].Len > a })
+		for ; sidx < len(bt.Segs) && bt.Segs[sidx].Pos < b; sidx++ {
+			seg := bt.Segs[sidx]
+			bb = bb.Union(seg.Slice(max(a, seg.Pos)-seg.Pos,
+				min(b, seg.Pos+seg.Len)-seg.Pos, seg.Len))
+		}
+		if bb == nil {
+			bb = bt.BBs[lidx].Slice(a-ls, b-ls, le-ls)
+		}
+
+		bbs = append(bbs, bb)
+		lidxs = append(lidxs, lidx)
+	}
+
+	return bbs, lidxs
+}
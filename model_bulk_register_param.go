@@ -50,6 +50,8 @@ const (
 	CsvStartPos
 	CsvEndPos
 	CsvMecabType
+	CsvManifest
+	CsvCanvasStart
 )
 
 var mu sync.Mutex
@@ -57,6 +59,8 @@ var mu sync.Mutex
 type BulkResult struct {
 	Message []string `json:"message"`
 	Error   []string `json:"error"`
+	// books whose OCR pages and canvases do not line up
+	Mismatch []*PageAlignment `json:"mismatch"`
 }
 
 func (br *BulkResult) AddMsgf(format string, a ...any) {
@@ -71,9 +75,21 @@ func (br *BulkResult) AddErrf(format string, a ...any) {
 	mu.Unlock()
 }
 
+func (br *BulkResult) AddMismatch(pa *PageAlignment) {
+	mu.Lock()
+	br.Mismatch = append(br.Mismatch, pa)
+	mu.Unlock()
+}
+
 // IndexData
 func (brp *BulkRegisterParam) BulkIndexData() (*BulkResult, error) {
-	msgs := &BulkResult{}
+	msgs := &BulkResult{Mismatch: []*PageAlignment{}}
+
+	if brp.Type != OCRFormatAuto {
+		if _, err := GetOCRFormat(brp.Type); err != nil {
+			return nil, err
+		}
+	}
 
 	//ioutil.ReadDir(cfg.BulkSourceDir)
 	if filepath.Ext(brp.ListFileHeader.Filename) != ".csv" {
@@ -87,7 +103,7 @@ func (brp *BulkRegisterParam) BulkIndexData() (*BulkResult, error) {
 	defer f.Close()
 
 	r := csv.NewReader(f)
-	// header: bid,cid,iid,vol,start,end
+	// header: bid,cid,iid,vol,start,end[,mecabType[,manifest[,canvasStart]]]
 	if row, err := r.Read(); err != nil || row[0] != "bid" {
 		return nil, fmt.Errorf("first line must be header")
 	}
@@ -114,10 +130,16 @@ func (brp *BulkRegisterParam) BulkIndexData() (*BulkResult, error) {
 					msgs.AddErrf("new %s: %s", rp.Bid, err)
 					continue
 				}
-				if err := bt.FetchKokushoMetadata(); err != nil {
+				if brp.Type == OCRFormatAuto {
+					msgs.AddMsgf("new %s: type detected: %s", rp.Bid, rp.Type)
+				}
+				if err := bt.FetchMetadata(rp.Manifest); err != nil {
 					msgs.AddErrf("new %s: %s", rp.Bid, err)
 					continue
 				}
+				if pa := bt.AlignPages(); pa.Mismatch() {
+					msgs.AddMismatch(pa)
+				}
 				q2 <- bt
 			}
 		}(&wg1, q1, q2, msgs)
@@ -153,9 +175,17 @@ func (brp *BulkRegisterParam) BulkIndexData() (*BulkResult, error) {
 			sp, _ := strconv.Atoi(row[CsvStartPos])
 			ep, _ := strconv.Atoi(row[CsvEndPos])
 			mt := ""
-			if len(row) == 7 {
+			if len(row) > CsvMecabType {
 				mt = row[CsvMecabType]
 			}
+			mf := ""
+			if len(row) > CsvManifest {
+				mf = row[CsvManifest]
+			}
+			cs := 0
+			if len(row) > CsvCanvasStart {
+				cs, _ = strconv.Atoi(row[CsvCanvasStart])
+			}
 			rp.MecabType = mt
 			rp.LocalPath = []string{path}
 			rp.StartPos = []int{sp}
@@ -166,10 +196,13 @@ func (brp *BulkRegisterParam) BulkIndexData() (*BulkResult, error) {
 				Bid:       row[CsvBid],
 				Cid:       row[CsvCid],
 				MecabType: mt,
+				Manifest:  mf,
 				Iid:       row[CsvIid],
 				LocalPath: []string{path},
 				StartPos:  []int{sp},
 				EndPos:    []int{ep},
+				// 0 (not given) for all rows means no explicit mapping
+				CanvasStart: []int{cs},
 			}
 		} else {
 			path := row[CsvIid]
@@ -182,10 +215,15 @@ func (brp *BulkRegisterParam) BulkIndexData() (*BulkResult, error) {
 
 			sp, _ := strconv.Atoi(row[CsvStartPos])
 			ep, _ := strconv.Atoi(row[CsvEndPos])
+			cs := 0
+			if len(row) > CsvCanvasStart {
+				cs, _ = strconv.Atoi(row[CsvCanvasStart])
+			}
 
 			rp.LocalPath = append(rp.LocalPath, path)
 			rp.StartPos = append(rp.StartPos, sp)
 			rp.EndPos = append(rp.EndPos, ep)
+			rp.CanvasStart = append(rp.CanvasStart, cs)
 		}
 	}
 
@@ -2,17 +2,23 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/ristretto"
 	"github.com/elastic/elastic-transport-go/v8/elastictransport"
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/closepointintime"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/get"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/indices/create"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/conflicts"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/dynamicmapping"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/highlightertagsschema"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/highlightertype"
@@ -21,6 +27,9 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/termvectoroption"
 )
 
+// keep alive of the point in time of SearchText
+const searchKeepAlive = "1m"
+
 type ES struct {
 	Client    *elasticsearch.TypedClient
 	Highlight *types.Highlight
@@ -84,40 +93,32 @@ func (es *ES) InitIndex(isForce bool) error {
 	}
 
 	// textProp
-	// icu => bigram
-	var (
-		tokenizer string = "my_bigram_tokenizer"
-		analyzer  string = "my_icu_ngram_analyzer"
-	)
-
-	customTokenizer := types.NewNGramTokenizer()
-	customTokenizer.MinGram = 2
-	customTokenizer.MaxGram = 2
-
-	customAnalyzer := types.NewCustomAnalyzer()
-	customAnalyzer.CharFilter = []string{"icu_normalizer"}
-	customAnalyzer.Tokenizer = tokenizer
-
 	customIndexOptions := &indexoptions.IndexOptions{}
 	customIndexOptions.Name = "positions"
 
 	customTermVector := &termvectoroption.TermVectorOption{}
 	customTermVector.Name = "with_positions_offsets"
 
+	kanaProp := types.NewTextProperty()
+	kanaProp.Analyzer = Str2Pt(kanaAnalyzer)
+	kanaProp.IndexOptions = customIndexOptions
+	kanaProp.TermVector = customTermVector
+
 	textProp := types.NewTextProperty()
-	textProp.Analyzer = &analyzer
+	textProp.Analyzer = Str2Pt(textAnalyzer)
 	textProp.IndexOptions = customIndexOptions
 	textProp.TermVector = customTermVector
+	textProp.Fields = map[string]types.Property{
+		"kana": kanaProp,
+	}
+
+	// readingProp: readings of the MeCab tokens; see BookText.Reading
+	readingProp := types.NewTextProperty()
+	readingProp.Analyzer = Str2Pt(textAnalyzer)
+	readingProp.IndexOptions = customIndexOptions
 
 	s := &types.IndexSettings{
-		Analysis: &types.IndexSettingsAnalysis{
-			Analyzer: map[string]types.Analyzer{
-				analyzer: customAnalyzer,
-			},
-			Tokenizer: map[string]types.Tokenizer{
-				tokenizer: customTokenizer,
-			},
-		},
+		Analysis: AnalysisSettings(currentItaijiTable()),
 		Mapping: &types.MappingLimitSettings{
 			NestedObjects: &types.MappingLimitSettingsNestedObjects{
 				Limit: Int2Pt(1e+6),
@@ -128,31 +129,58 @@ func (es *ES) InitIndex(isForce bool) error {
 	// bbsProp
 	bbsProp := types.NewNestedProperty()
 	bbsProp.Properties = map[string]types.Property{
-		"x": types.NewIntegerNumberProperty(),
-		"y": types.NewIntegerNumberProperty(),
-		"w": types.NewIntegerNumberProperty(),
-		"h": types.NewIntegerNumberProperty(),
+		"x":    types.NewIntegerNumberProperty(),
+		"y":    types.NewIntegerNumberProperty(),
+		"w":    types.NewIntegerNumberProperty(),
+		"h":    types.NewIntegerNumberProperty(),
+		"conf": types.NewFloatNumberProperty(),
 	}
 
+	// segsProp: only stored to compute highlight boxes
+	segsProp := types.NewObjectProperty()
+	segsProp.Enabled = Bool2Pt(false)
+
+	// ocrImagesProp, canvasesProp: page image sizes
+	ocrImagesProp := types.NewObjectProperty()
+	ocrImagesProp.Enabled = Bool2Pt(false)
+	canvasesProp := types.NewObjectProperty()
+	canvasesProp.Enabled = Bool2Pt(false)
+
+	// mecabSpansProp: only stored to highlight tokens
+	mecabSpansProp := types.NewObjectProperty()
+	mecabSpansProp.Enabled = Bool2Pt(false)
+
 	// see type BookText
 	m := &types.TypeMapping{
 		Dynamic: &dynamicmapping.Strict,
 		Properties: map[string]types.Property{
-			"bid":         types.NewKeywordProperty(),
-			"cid":         types.NewKeywordProperty(),
-			"elevel":      types.NewKeywordProperty(),
-			"tags":        types.NewKeywordProperty(),
-			"label":       types.NewKeywordProperty(),
-			"metadata":    labelValueProp,
-			"attribution": types.NewKeywordProperty(),
-			"license":     types.NewKeywordProperty(),
-			"images":      types.NewKeywordProperty(),
-			"text":        textProp,
-			"pbs":         types.NewIntegerNumberProperty(),
-			"lbs":         types.NewIntegerNumberProperty(),
-			"bbs":         bbsProp,
-			"mecabType":   types.NewKeywordProperty(),
-			"mecabed":     types.NewKeywordProperty(),
+			"bid":                types.NewKeywordProperty(),
+			"cid":                types.NewKeywordProperty(),
+			"elevel":             types.NewKeywordProperty(),
+			"tags":               types.NewKeywordProperty(),
+			"label":              types.NewKeywordProperty(),
+			"metadata":           labelValueProp,
+			"biblio":             BiblioProperty(),
+			"attribution":        types.NewKeywordProperty(),
+			"license":            types.NewKeywordProperty(),
+			"images":             types.NewKeywordProperty(),
+			"canvases":           canvasesProp,
+			"manifest":           types.NewKeywordProperty(),
+			"pageCanvases":       types.NewIntegerNumberProperty(),
+			"pageAlignment":      types.NewKeywordProperty(),
+			"text":               textProp,
+			"pbs":                types.NewIntegerNumberProperty(),
+			"lbs":                types.NewIntegerNumberProperty(),
+			"bbs":                bbsProp,
+			"segs":               segsProp,
+			"ocrImages":          ocrImagesProp,
+			"mecabType":          types.NewKeywordProperty(),
+			"mecabed":            types.NewKeywordProperty(),
+			"mecabedExpanded":    types.NewKeywordProperty(),
+			"mecabSpans":         mecabSpansProp,
+			"mecabExpandedSpans": mecabSpansProp,
+			"reading":            readingProp,
+			"readingSpans":       mecabSpansProp,
 		},
 	}
 	_, err = es.Client.Indices.Create(cfg.IndexName).
@@ -223,33 +251,351 @@ func (es *ES) CountRecord() (*search.Response, error) {
 	return data, nil
 }
 
+// number of hits got by a request of SearchText
+const searchPageSize = 100
+
+// characters of a highlighted fragment
+const fragmentSize = 50
+
+// max hit documents of a search if SearchMaxHits is unset
+const defaultSearchMaxHits = 1000
+
+// aggregation of the number of hit books
+const bookTotalAgg = "bookTotal"
+
+// searchMaxHits returns SearchMaxHits or the default; 0: unlimited
+func searchMaxHits() int {
+	if cfg.SearchMaxHits == nil {
+		return defaultSearchMaxHits
+	}
+	return *cfg.SearchMaxHits
+}
+
+// searchSourceExcludes returns the fields of the documents not needed for
+// the matches of q
+func searchSourceExcludes(q *QueryNode) []string {
+	excludes := []string{}
+	if !q.HasMorph() {
+		excludes = append(excludes, "mecabed", "mecabedExpanded", "mecabExpandedSpans")
+	}
+	if !q.ByReading {
+		excludes = append(excludes, "reading", "readingSpans")
+	}
+	if !q.HasMorph() && !q.ByReading {
+		excludes = append(excludes, "mecabSpans")
+	}
+	return excludes
+}
+
+// SearchText returns all the hits, got page by page with a point in
+// time and search_after, in a response; aggregations and the total are
+// those of the first page
 func (es *ES) SearchText(sp *TextSearchParam) (*search.Response, error) {
-	data, err := es.Client.Search().
-		Index(cfg.IndexName).
-		Query(sp.GetESQuery()).
-		Highlight(&types.Highlight{
-			Fields: map[string]types.HighlightField{
-				"text": {
-					Type:                  &highlightertype.Fvh,
-					BoundaryScannerLocale: Str2Pt("ja-JP"),
-					FragmentSize:          Int2Pt(50),
-					NumberOfFragments:     Int2Pt(math.MaxInt16),
-					NoMatchSize:           Int2Pt(0),
+	ctx := context.Background()
+
+	pit, err := es.Client.OpenPointInTime(cfg.IndexName).
+		KeepAlive(searchKeepAlive).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pitId := pit.Id
+	defer func() {
+		if _, err := es.Client.ClosePointInTime().
+			Request(&closepointintime.Request{Id: pitId}).
+			Do(ctx); err != nil {
+			fmt.Printf("close point in time: %s\n", err)
+		}
+	}()
+
+	hf := types.HighlightField{
+		Type:                  &highlightertype.Fvh,
+		BoundaryScannerLocale: Str2Pt("ja-JP"),
+		FragmentSize:          Int2Pt(sp.Query.FragmentSize(fragmentSize)),
+		NumberOfFragments:     Int2Pt(math.MaxInt16),
+		NoMatchSize:           Int2Pt(0),
+	}
+	if sp.Query.HasNear() || sp.Query.HasWildcard() {
+		hf.HighlightQuery = sp.Query.HighlightQuery()
+	}
+	hl := &types.Highlight{
+		Fields: map[string]types.HighlightField{
+			sp.Query.TextField(): hf,
+		},
+		TagsSchema: &highlightertagsschema.Styled,
+	}
+	// the readings are highlighted in the text by ReadingFragments
+	if sp.Query.ByReading {
+		hl = nil
+	}
+
+	var (
+		data  *search.Response
+		after []types.FieldValue
+	)
+	for {
+		req := es.Client.Search().
+			Pit(&types.PointInTimeReference{
+				Id:        pitId,
+				KeepAlive: searchKeepAlive,
+			}).
+			Query(sp.GetESQuery()).
+			SourceExcludes_(searchSourceExcludes(sp.Query)...).
+			Highlight(hl).
+			// the tiebreaker _shard_doc is implied with a point in time
+			Sort(&types.SortOptions{
+				SortOptions: map[string]types.FieldSort{
+					"bid": {
+						Order: &sortorder.Asc,
+					},
 				},
-			},
-			TagsSchema: &highlightertagsschema.Styled,
-		}).
-		Sort(&types.SortOptions{
-			SortOptions: map[string]types.FieldSort{
+			}).
+			Size(searchPageSize)
+		if after == nil {
+			aggs := FacetAggregations()
+			aggs[bookTotalAgg] = types.Aggregations{
+				Cardinality: &types.CardinalityAggregation{
+					Field: Str2Pt("bid"),
+					// the max of ES
+					PrecisionThreshold: Int2Pt(40000),
+				},
+			}
+			req = req.
+				Aggregations(aggs).
+				TrackTotalHits(true)
+		} else {
+			req = req.
+				SearchAfter(after...).
+				TrackTotalHits(false)
+		}
+
+		res, err := req.Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if res.PitId != nil {
+			pitId = *res.PitId
+		}
+
+		if data == nil {
+			data = res
+		} else {
+			data.Hits.Hits = append(data.Hits.Hits, res.Hits.Hits...)
+		}
+
+		hits := res.Hits.Hits
+		if len(hits) < searchPageSize {
+			return data, nil
+		}
+		if n := searchMaxHits(); n > 0 && len(data.Hits.Hits) >= n {
+			data.Hits.Hits = data.Hits.Hits[:n]
+			return data, nil
+		}
+		after = hits[len(hits)-1].Sort
+	}
+}
+
+// AllBids returns all the bids in the index
+func (es *ES) AllBids() ([]string, error) {
+	bids := []string{}
+	var after types.CompositeAggregateKey
+	for {
+		data, err := es.Client.Search().
+			Index(cfg.IndexName).
+			Size(0).
+			Aggregations(map[string]types.Aggregations{
+				"bids": {
+					Composite: &types.CompositeAggregation{
+						After: after,
+						Size:  Int2Pt(1000),
+						Sources: []map[string]types.CompositeAggregationSource{{
+							"bid": {Terms: &types.CompositeTermsAggregation{
+								Field: Str2Pt("bid"),
+							}},
+						}},
+					},
+				},
+			}).
+			Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		ca, ok := data.Aggregations["bids"].(*types.CompositeAggregate)
+		if !ok {
+			return nil, fmt.Errorf("unexpected aggregation: %T", data.Aggregations["bids"])
+		}
+		buckets, _ := ca.Buckets.([]types.CompositeBucket)
+		for _, b := range buckets {
+			if bid, ok := b.Key["bid"].(string); ok {
+				bids = append(bids, bid)
+			}
+		}
+		if len(buckets) == 0 || ca.AfterKey == nil {
+			return bids, nil
+		}
+		after = ca.AfterKey
+	}
+}
+
+// SearchBookMetadata returns the documents of bid with the fields
+// derived from the manifest
+func (es *ES) SearchBookMetadata(bid string) (*search.Response, error) {
+	return es.Client.Search().
+		Index(cfg.IndexName).
+		Query(&types.Query{
+			Term: map[string]types.TermQuery{
 				"bid": {
-					Order: &sortorder.Asc,
+					Value: bid,
 				},
 			},
 		}).
+		SourceIncludes_("bid", "cid", "elevel", "tags", "label", "metadata",
+			"biblio", "attribution", "license", "images", "canvases", "manifest",
+			// to map the pages again
+			"pbs", "ocrImages", "pageCanvases", "pageAlignment").
+		Size(100).
+		Do(context.Background())
+}
+
+// UpdateBookData partially updates the document of id with doc
+func (es *ES) UpdateBookData(id string, doc any) error {
+	res, err := es.Client.Update(cfg.IndexName, id).
+		Doc(doc).
 		Do(context.Background())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return data, nil
+	fmt.Printf("document updated: %v\n", res)
+	return nil
+}
+
+// errIndexBusy is returned if the index cannot be closed now
+var errIndexBusy = errors.New("index busy")
+
+// UpdateAnalysis puts AnalysisSettings of t to the index, which is closed
+// in the meantime, so that search and register fail; errIndexBusy if the
+// index is closed or being updated by query, e.g. reindexed
+func (es *ES) UpdateAnalysis(t ItaijiTable) error {
+	ctx := context.Background()
+	if err := es.checkIndexIdle(ctx); err != nil {
+		return err
+	}
+
+	if _, err := es.Client.Indices.Close(cfg.IndexName).Do(ctx); err != nil {
+		return fmt.Errorf("close index: %s", err)
+	}
+
+	_, err := es.Client.Indices.PutSettings().
+		Indices(cfg.IndexName).
+		Analysis(AnalysisSettings(t)).
+		Do(ctx)
+	if err != nil {
+		err = fmt.Errorf("put settings: %s", err)
+	}
+
+	// reopened anyway
+	if _, oerr := es.Client.Indices.Open(cfg.IndexName).Do(ctx); oerr != nil {
+		return fmt.Errorf("open index: %s", oerr)
+	}
+	return err
+}
+
+// action of update_by_query tasks
+const updateByQueryAction = "indices:data/write/update/byquery"
+
+// checkIndexIdle returns errIndexBusy if the index is closed or documents
+// of it are being updated by query
+func (es *ES) checkIndexIdle(ctx context.Context) error {
+	recs, err := es.Client.Cat.Indices().Index(cfg.IndexName).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("index status: %s", err)
+	}
+	for _, r := range recs {
+		if r.Status != nil && *r.Status == "close" {
+			return fmt.Errorf("%w: %s closed", errIndexBusy, cfg.IndexName)
+		}
+	}
+
+	res, err := es.Client.Tasks.List().
+		Actions(updateByQueryAction).
+		Detailed(true).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("list tasks: %s", err)
+	}
+	for node, nt := range res.Nodes {
+		for _, ti := range nt.Tasks {
+			// e.g. "update-by-query [ftb]"
+			if ti.Description != nil &&
+				strings.Contains(*ti.Description, "["+cfg.IndexName+"]") {
+				return fmt.Errorf("%w: task %s:%d running", errIndexBusy, node, ti.Id)
+			}
+		}
+	}
+	return nil
+}
+
+// ReindexText starts to update all the documents in place to analyze
+// text again; returns the task id
+func (es *ES) ReindexText() (string, error) {
+	res, err := es.Client.UpdateByQuery(cfg.IndexName).
+		Conflicts(conflicts.Proceed).
+		WaitForCompletion(false).
+		Do(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("reindex started: %v\n", res.Task)
+	return fmt.Sprint(res.Task), nil
+}
+
+// times to retry to get a task in a row
+const waitTaskRetries = 3
+
+// WaitTask polls the task of ES every interval until it completes; an
+// error if the task fails, or if it cannot be got waitTaskRetries times
+// in a row
+func (es *ES) WaitTask(task string, interval time.Duration) error {
+	retries := 0
+	for {
+		res, err := es.Client.Tasks.Get(task).Do(context.Background())
+		if err != nil {
+			if retries++; retries > waitTaskRetries {
+				return fmt.Errorf("get task %s: %s", task, err)
+			}
+			time.Sleep(interval)
+			continue
+		}
+		retries = 0
+		if res.Completed {
+			if res.Error != nil {
+				return fmt.Errorf("task %s: %s", task, res.Error.Type)
+			}
+			if n, err := taskFailures(res.Response); err != nil {
+				return fmt.Errorf("task %s: %s", task, err)
+			} else if n > 0 {
+				return fmt.Errorf("task %s: %d documents failed", task, n)
+			}
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+// taskFailures returns the number of failures in the response of a
+// completed update_by_query task
+func taskFailures(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	var res struct {
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return 0, err
+	}
+	return len(res.Failures), nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestSearchSourceExcludes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q         string
+		byReading bool
+		want      []string
+	}{
+		{"和歌", false, []string{
+			"mecabed", "mecabedExpanded", "mecabExpandedSpans",
+			"reading", "readingSpans", "mecabSpans",
+		}},
+		{"pos:助動詞 lemma:けり", false, []string{"reading", "readingSpans"}},
+		{"わか", true, []string{"mecabed", "mecabedExpanded", "mecabExpandedSpans"}},
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.ByReading = tt.byReading
+		if diff := cmp.Diff(tt.want, searchSourceExcludes(n)); diff != "" {
+			t.Errorf("searchSourceExcludes(%s) mismatch (-want +got):\n%s", tt.q, diff)
+		}
+	}
+}
+
+// not parallel, as cfg is set
+func TestSearchMaxHits(t *testing.T) {
+	orig := cfg
+	defer func() { cfg = orig }()
+
+	cfg = &Config{}
+	if got := searchMaxHits(); got != defaultSearchMaxHits {
+		t.Errorf("searchMaxHits => %d, want %d", got, defaultSearchMaxHits)
+	}
+	cfg = &Config{SearchMaxHits: Int2Pt(0)}
+	if got := searchMaxHits(); got != 0 {
+		t.Errorf("searchMaxHits => %d, want 0", got)
+	}
+}
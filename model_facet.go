@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// max values of a facet
+const facetSize = 100
+
+/* Facet */
+// Facet is a value of a field with the number of the hit documents;
+// Value can be given as a filter of the next query
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+/* TextSearchFilters */
+type TextSearchFilters struct {
+	Keyword     TextSearchKeywordFilter `json:"keyword"`
+	Tag         []*Facet                `json:"tag"`
+	ELevel      []*Facet                `json:"elevel"`
+	License     []*Facet                `json:"license"`
+	Attribution []*Facet                `json:"attribution"`
+	// by MetadataField.Name
+	Biblio map[string][]*Facet `json:"biblio"`
+}
+
+// facetFields returns the aggregation names and the fields of facets
+func facetFields() map[string]string {
+	fields := map[string]string{
+		"tag":         "tags",
+		"elevel":      "elevel",
+		"license":     "license",
+		"attribution": "attribution",
+	}
+	for _, f := range MetadataFields() {
+		fields["biblio."+f.Name] = "biblio." + f.Name
+	}
+	return fields
+}
+
+// FacetAggregations returns the terms aggregations of the facets
+func FacetAggregations() map[string]types.Aggregations {
+	aggs := map[string]types.Aggregations{}
+	for name, field := range facetFields() {
+		aggs[name] = types.Aggregations{
+			Terms: &types.TermsAggregation{
+				Field: Str2Pt(field),
+				Size:  Int2Pt(facetSize),
+			},
+		}
+	}
+	return aggs
+}
+
+// NewTextSearchFilters sets facets from the aggregations
+func NewTextSearchFilters(kwf TextSearchKeywordFilter, aggs map[string]types.Aggregate) (*TextSearchFilters, error) {
+	f := &TextSearchFilters{
+		Keyword:     kwf,
+		Tag:         []*Facet{},
+		ELevel:      []*Facet{},
+		License:     []*Facet{},
+		Attribution: []*Facet{},
+		Biblio:      map[string][]*Facet{},
+	}
+
+	for name := range facetFields() {
+		facets, err := newFacets(aggs[name])
+		if err != nil {
+			return nil, fmt.Errorf("facet %s: %s", name, err)
+		}
+		switch name {
+		case "tag":
+			f.Tag = facets
+		case "elevel":
+			f.ELevel = facets
+		case "license":
+			f.License = facets
+		case "attribution":
+			f.Attribution = facets
+		default:
+			f.Biblio[name[len("biblio."):]] = facets
+		}
+	}
+
+	return f, nil
+}
+
+func newFacets(agg types.Aggregate) ([]*Facet, error) {
+	facets := []*Facet{}
+	switch a := agg.(type) {
+	case nil:
+		// no aggregation, e.g. cached before
+	case *types.StringTermsAggregate:
+		buckets, _ := a.Buckets.([]types.StringTermsBucket)
+		for _, b := range buckets {
+			facets = append(facets, &Facet{
+				Value: fmt.Sprint(b.Key),
+				Count: int(b.DocCount),
+			})
+		}
+	case *types.LongTermsAggregate:
+		buckets, _ := a.Buckets.([]types.LongTermsBucket)
+		for _, b := range buckets {
+			facets = append(facets, &Facet{
+				Value: fmt.Sprint(b.Key),
+				Count: int(b.DocCount),
+			})
+		}
+	case *types.UnmappedTermsAggregate:
+		// field not in the mapping
+	default:
+		return nil, fmt.Errorf("unexpected aggregation: %T", agg)
+	}
+	return facets, nil
+}
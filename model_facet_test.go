@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestNewTextSearchFilters(t *testing.T) {
+	t.Parallel()
+
+	var res search.Response
+	if err := json.Unmarshal([]byte(`{
+		"hits": {"hits": []},
+		"aggregations": {
+			"sterms#tag": {"buckets": [
+				{"key": "ndlocrv2", "doc_count": 120},
+				{"key": "ndlocrv1", "doc_count": 43}
+			]},
+			"sterms#elevel": {"buckets": [{"key": "OCR", "doc_count": 163}]},
+			"sterms#license": {"buckets": []},
+			"umterms#attribution": {"buckets": []},
+			"sterms#biblio.author": {"buckets": [{"key": "鴨長明", "doc_count": 2}]},
+			"lterms#biblio.year": {"buckets": [{"key": 1626, "doc_count": 1}]}
+		}
+	}`), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewTextSearchFilters(TextSearchKeywordFilter{}, res.Aggregations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &TextSearchFilters{
+		Keyword: TextSearchKeywordFilter{},
+		Tag: []*Facet{
+			{Value: "ndlocrv2", Count: 120},
+			{Value: "ndlocrv1", Count: 43},
+		},
+		ELevel:      []*Facet{{Value: "OCR", Count: 163}},
+		License:     []*Facet{},
+		Attribution: []*Facet{},
+		Biblio: map[string][]*Facet{
+			"author": {{Value: "鴨長明", Count: 2}},
+			"year":   {{Value: "1626", Count: 1}},
+			"genre":  {},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NewTextSearchFilters mismatch (-want +got):\n%s", diff)
+	}
+}
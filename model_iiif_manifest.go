@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* IIIFManifest */
+// IIIFManifest is the part of a IIIF manifest used by BookText,
+// independent of the Presentation API version
+type IIIFManifest struct {
+	Version     int
+	Label       string
+	Metadata    []*LabelValue
+	Attribution string
+	License     string
+	Images      []string
+	Canvases    []Canvas
+}
+
+/* IIIFManifestMetadata */
+// IIIFManifestMetadata is a Presentation API 2.x manifest
+type IIIFManifestMetadata struct {
+	Label       IIIFValue         `json:"label"`
+	Metadata    []*IIIFLabelValue `json:"metadata"`
+	Attribution IIIFValue         `json:"attribution"`
+	License     IIIFValue         `json:"license"`
+	Sequences   []struct {
+		Canvases []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+			Images []struct {
+				Resource struct {
+					ID      string       `json:"@id"`
+					Service IIIFServices `json:"service"`
+				} `json:"resource"`
+			} `json:"images"`
+		} `json:"canvases"`
+	} `json:"sequences"`
+}
+
+/* IIIFManifestV3 */
+// IIIFManifestV3 is a Presentation API 3.0 manifest
+type IIIFManifestV3 struct {
+	Label             IIIFValue         `json:"label"`
+	Metadata          []*IIIFLabelValue `json:"metadata"`
+	RequiredStatement *IIIFLabelValue   `json:"requiredStatement"`
+	Rights            string            `json:"rights"`
+	Items             []struct {
+		Type   string `json:"type"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+		Items  []struct {
+			Items []struct {
+				Motivation string       `json:"motivation"`
+				Body       IIIFV3Bodies `json:"body"`
+			} `json:"items"`
+		} `json:"items"`
+	} `json:"items"`
+}
+
+/* IIIFV3Body */
+type IIIFV3Body struct {
+	ID      string       `json:"id"`
+	Type    string       `json:"type"`
+	Service IIIFServices `json:"service"`
+}
+
+/* IIIFV3Bodies */
+// IIIFV3Bodies accepts a body or an array of bodies
+type IIIFV3Bodies []IIIFV3Body
+
+func (bs *IIIFV3Bodies) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]IIIFV3Body)(bs))
+	}
+	var b IIIFV3Body
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	*bs = IIIFV3Bodies{b}
+	return nil
+}
+
+/* IIIFService */
+type IIIFService struct {
+	ID   string `json:"id"`
+	ID2  string `json:"@id"` // v2
+	Type string `json:"type"`
+}
+
+/* IIIFServices */
+// IIIFServices accepts a service or an array of services (v2 or v3)
+type IIIFServices []IIIFService
+
+func (ss *IIIFServices) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var v []json.RawMessage
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		for _, raw := range v {
+			var s IIIFServices
+			if err := s.UnmarshalJSON(raw); err != nil {
+				return err
+			}
+			*ss = append(*ss, s...)
+		}
+		return nil
+	}
+	var s IIIFService
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*ss = append(*ss, s)
+	return nil
+}
+
+// ImageService returns the id of the first service, if any
+func (ss IIIFServices) ImageService() string {
+	for _, s := range ss {
+		if s.ID != "" {
+			return s.ID
+		}
+		if s.ID2 != "" {
+			return s.ID2
+		}
+	}
+	return ""
+}
+
+/* IIIFLabelValue */
+type IIIFLabelValue struct {
+	Label IIIFValue `json:"label"`
+	Value IIIFValue `json:"value"`
+}
+
+/* IIIFValue */
+// IIIFValue is a string from a plain string, a v2 language-tagged
+// value ({"@value": .., "@language": ..}), an array of them, or a v3
+// language map ({"ja": [..], "none": [..]})
+type IIIFValue string
+
+// preferred languages of language-tagged values
+var iiifLanguages = []string{"ja", "none", "en"}
+
+func (v *IIIFValue) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*v = IIIFValue(iiifValueString(raw))
+	return nil
+}
+
+func iiifValueString(raw any) string {
+	switch x := raw.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64, bool:
+		return fmt.Sprint(x)
+	case []any:
+		// v2: pick values of the preferred language if tagged
+		byLang := map[string][]any{}
+		for _, e := range x {
+			lang := "none"
+			if o, ok := e.(map[string]any); ok {
+				if l, ok := o["@language"].(string); ok {
+					lang = l
+				}
+			}
+			byLang[lang] = append(byLang[lang], e)
+		}
+		vals := byLang[pickLanguage(byLang)]
+		ss := make([]string, 0, len(vals))
+		for _, e := range vals {
+			if s := iiifValueString(e); s != "" {
+				ss = append(ss, s)
+			}
+		}
+		return strings.Join(ss, "; ")
+	case map[string]any:
+		if val, ok := x["@value"]; ok {
+			return iiifValueString(val)
+		}
+		// v3 language map
+		byLang := map[string][]any{}
+		for lang, val := range x {
+			if a, ok := val.([]any); ok {
+				byLang[lang] = a
+			} else {
+				byLang[lang] = []any{val}
+			}
+		}
+		return iiifValueString(byLang[pickLanguage(byLang)])
+	}
+	return ""
+}
+
+// pickLanguage returns the preferred language in m; if none of
+// iiifLanguages is present, the first one in sorted order
+func pickLanguage[T any](m map[string]T) string {
+	for _, lang := range iiifLanguages {
+		if _, ok := m[lang]; ok {
+			return lang
+		}
+	}
+	langs := make([]string, 0, len(m))
+	for lang := range m {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	if len(langs) == 0 {
+		return ""
+	}
+	return langs[0]
+}
+
+// ParseManifest parses a IIIF Presentation API 2.x or 3.0 manifest;
+// the version is decided by @context
+func ParseManifest(data []byte) (*IIIFManifest, error) {
+	var ctx struct {
+		Context any `json:"@context"`
+	}
+	if err := json.Unmarshal(data, &ctx); err != nil {
+		return nil, fmt.Errorf("manifest parsing failed: %s", err)
+	}
+
+	if manifestVersion(ctx.Context) == 3 {
+		return parseManifestV3(data)
+	}
+	return parseManifestV2(data)
+}
+
+// manifestVersion returns 3 if the @context includes the Presentation
+// API 3 context, otherwise 2
+func manifestVersion(ctx any) int {
+	switch x := ctx.(type) {
+	case string:
+		if strings.Contains(x, "/presentation/3/") {
+			return 3
+		}
+	case []any:
+		for _, c := range x {
+			if manifestVersion(c) == 3 {
+				return 3
+			}
+		}
+	}
+	return 2
+}
+
+func parseManifestV2(data []byte) (*IIIFManifest, error) {
+	var m IIIFManifestMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest v2 parsing failed: %s", err)
+	}
+
+	mf := &IIIFManifest{
+		Version:     2,
+		Label:       string(m.Label),
+		Metadata:    labelValues(m.Metadata),
+		Attribution: string(m.Attribution),
+		License:     string(m.License),
+		Images:      []string{},
+		Canvases:    []Canvas{},
+	}
+	if len(m.Sequences) == 0 {
+		return mf, nil
+	}
+
+	for _, c := range m.Sequences[0].Canvases {
+		img := ""
+		if len(c.Images) > 0 {
+			res := c.Images[0].Resource
+			img = imageIdentifier(res.ID, res.Service.ImageService())
+		}
+		mf.Images = append(mf.Images, img)
+		mf.Canvases = append(mf.Canvases, Canvas{Width: c.Width, Height: c.Height})
+	}
+
+	return mf, nil
+}
+
+func parseManifestV3(data []byte) (*IIIFManifest, error) {
+	var m IIIFManifestV3
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest v3 parsing failed: %s", err)
+	}
+
+	mf := &IIIFManifest{
+		Version:  3,
+		Label:    string(m.Label),
+		Metadata: labelValues(m.Metadata),
+		License:  m.Rights,
+		Images:   []string{},
+		Canvases: []Canvas{},
+	}
+	if m.RequiredStatement != nil {
+		mf.Attribution = string(m.RequiredStatement.Value)
+	}
+
+	for _, c := range m.Items {
+		if c.Type != "" && c.Type != "Canvas" {
+			continue
+		}
+		img := ""
+	pages:
+		for _, page := range c.Items {
+			for _, anno := range page.Items {
+				if anno.Motivation != "" && anno.Motivation != "painting" {
+					continue
+				}
+				for _, body := range anno.Body {
+					if body.Type == "" || body.Type == "Image" {
+						img = imageIdentifier(body.ID, body.Service.ImageService())
+						break pages
+					}
+				}
+			}
+		}
+		mf.Images = append(mf.Images, img)
+		mf.Canvases = append(mf.Canvases, Canvas{Width: c.Width, Height: c.Height})
+	}
+
+	return mf, nil
+}
+
+func labelValues(lvs []*IIIFLabelValue) []*LabelValue {
+	md := make([]*LabelValue, 0, len(lvs))
+	for _, lv := range lvs {
+		if lv == nil {
+			continue
+		}
+		md = append(md, &LabelValue{
+			Label: string(lv.Label),
+			Value: string(lv.Value),
+		})
+	}
+	return md
+}
+
+// imageIdentifier returns the image identifier used for Images: the
+// kokusho image path ".../xxx.tif", the image service id, or the image
+// id without the IIIF Image API request
+func imageIdentifier(id, service string) string {
+	if idx := strings.Index(id, ".tif/"); idx != -1 {
+		return id[0 : idx+4]
+	}
+	if service != "" {
+		return strings.TrimSuffix(service, "/")
+	}
+	if idx := strings.Index(id, "/full/"); idx != -1 {
+		return id[0:idx]
+	}
+	return id
+}
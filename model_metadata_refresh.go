@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+/* MetadataRefreshParam */
+type MetadataRefreshParam struct {
+	Bids []string `json:"bids" form:"bids"`
+	All  bool     `json:"all" form:"all"`
+}
+
+/* MetadataRefreshResult */
+type MetadataRefreshResult struct {
+	Changed   []*MetadataChange `json:"changed"`
+	Unchanged int               `json:"unchanged"`
+	Error     []string          `json:"error"`
+
+	mu sync.Mutex
+}
+
+/* MetadataChange */
+type MetadataChange struct {
+	Id     string   `json:"id"`
+	Bid    string   `json:"bid"`
+	Fields []string `json:"fields"`
+	// the pages mapped again as the images or canvases changed
+	Alignment *PageAlignment `json:"alignment,omitempty"`
+}
+
+// Refresh refetches the manifests of the books and updates the fields
+// derived from them in the index, leaving those derived from OCR as is
+func (rp *MetadataRefreshParam) Refresh(es *ES) (*MetadataRefreshResult, error) {
+	bids := rp.Bids
+	if rp.All {
+		var err error
+		if bids, err = es.AllBids(); err != nil {
+			return nil, fmt.Errorf("list bids: %s", err)
+		}
+	}
+
+	res := &MetadataRefreshResult{
+		Changed: []*MetadataChange{},
+		Error:   []string{},
+	}
+
+	var wg sync.WaitGroup
+	q := make(chan string)
+	for i := 0; i < max(cfg.BulkWorkerNum, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bid := range q {
+				if err := res.refresh(es, bid); err != nil {
+					res.addErrf("refresh %s: %s", bid, err)
+				}
+			}
+		}()
+	}
+	for _, bid := range bids {
+		q <- bid
+	}
+	close(q)
+	wg.Wait()
+
+	// search results hold metadata
+	if len(res.Changed) > 0 {
+		es.Cache.Clear()
+	}
+
+	return res, nil
+}
+
+func (res *MetadataRefreshResult) refresh(es *ES, bid string) error {
+	data, err := es.SearchBookMetadata(bid)
+	if err != nil {
+		return err
+	}
+	if len(data.Hits.Hits) == 0 {
+		return fmt.Errorf("not found")
+	}
+
+	var m *IIIFManifest
+	for _, hit := range data.Hits.Hits {
+		var bt BookText
+		if err := json.Unmarshal(hit.Source_, &bt); err != nil {
+			return err
+		}
+
+		// fetched once per bid
+		if m == nil {
+			raw, src, err := RefetchManifest(bt.Bid, bt.Manifest)
+			if err != nil {
+				return err
+			}
+			if m, err = ParseManifest(raw); err != nil {
+				return fmt.Errorf("%s: %s", src, err)
+			}
+		}
+
+		fields, doc := bt.metadataDiff(m)
+		var pa *PageAlignment
+		if slices.Contains(fields, "images") || slices.Contains(fields, "canvases") {
+			fields, pa = bt.realign(m, fields, doc)
+		}
+		if len(fields) == 0 {
+			res.addUnchanged()
+			continue
+		}
+		if err := es.UpdateBookData(hit.Id_, doc); err != nil {
+			return fmt.Errorf("%s: %s", hit.Id_, err)
+		}
+		res.addChanged(&MetadataChange{
+			Id:        hit.Id_,
+			Bid:       bt.Bid,
+			Fields:    fields,
+			Alignment: pa,
+		})
+	}
+
+	return nil
+}
+
+// metadataDiff returns the names of the fields differing from m and
+// the partial document to update them
+func (bt *BookText) metadataDiff(m *IIIFManifest) ([]string, map[string]any) {
+	fields := []string{}
+	doc := map[string]any{}
+	diff := func(name string, changed bool, v any) {
+		if changed {
+			fields = append(fields, name)
+			doc[name] = v
+		}
+	}
+
+	diff("label", bt.Label != m.Label, m.Label)
+	diff("metadata", !slices.EqualFunc(bt.Metadata, m.Metadata,
+		func(a, b *LabelValue) bool { return *a == *b }), m.Metadata)
+	// typed values follow metadata and the current MetadataFields
+	biblio := NewBiblio(m.Metadata)
+	diff("biblio", !reflect.DeepEqual(normalizeJSON(bt.Biblio), normalizeJSON(biblio)), biblio)
+	diff("attribution", bt.Attribution != m.Attribution, m.Attribution)
+	diff("license", bt.License != m.License, m.License)
+	diff("images", !slices.Equal(bt.Images, m.Images), m.Images)
+	// kept along with images to scale boxes
+	diff("canvases", !slices.Equal(bt.Canvases, m.Canvases), m.Canvases)
+
+	return fields, doc
+}
+
+// realign maps the pages of bt to the images and canvases of m again,
+// keeping the mapping by canvasStart, and adds the changes to fields and
+// doc
+func (bt *BookText) realign(m *IIIFManifest, fields []string, doc map[string]any) ([]string, *PageAlignment) {
+	pcs, method := bt.PageCanvases, bt.PageAlignment
+	bt.Images, bt.Canvases = m.Images, m.Canvases
+	if bt.PageAlignment != AlignExplicit {
+		bt.PageCanvases = nil
+	}
+	pa := bt.AlignPages()
+
+	if !slices.Equal(pcs, bt.PageCanvases) {
+		fields = append(fields, "pageCanvases")
+		doc["pageCanvases"] = bt.PageCanvases
+	}
+	if method != bt.PageAlignment {
+		fields = append(fields, "pageAlignment")
+		doc["pageAlignment"] = bt.PageAlignment
+	}
+	return fields, pa
+}
+
+// normalizeJSON returns v as decoded from JSON to compare it with a
+// value decoded from the index
+func normalizeJSON(v any) any {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var n any
+	json.Unmarshal(raw, &n)
+	return n
+}
+
+func (res *MetadataRefreshResult) addChanged(mc *MetadataChange) {
+	res.mu.Lock()
+	res.Changed = append(res.Changed, mc)
+	res.mu.Unlock()
+}
+
+func (res *MetadataRefreshResult) addUnchanged() {
+	res.mu.Lock()
+	res.Unchanged++
+	res.mu.Unlock()
+}
+
+func (res *MetadataRefreshResult) addErrf(format string, a ...any) {
+	res.mu.Lock()
+	res.Error = append(res.Error, fmt.Sprintf(format, a...))
+	res.mu.Unlock()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestBookTextMetadataDiff(t *testing.T) {
+	t.Parallel()
+
+	raw, err := os.ReadFile(filepath.Join(manifestDir, "200000001", "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseManifest(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		bt := &BookText{}
+		if err := bt.SetManifest(raw); err != nil {
+			t.Fatal(err)
+		}
+		if fields, _ := bt.metadataDiff(m); len(fields) != 0 {
+			t.Errorf("metadataDiff => %v, want none", fields)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		bt := &BookText{}
+		if err := bt.SetManifest(raw); err != nil {
+			t.Fatal(err)
+		}
+		bt.Label = "方丈記抄"
+		bt.Metadata[1].Value = "寛永4"
+		bt.Images = bt.Images[:1]
+
+		fields, doc := bt.metadataDiff(m)
+		if diff := cmp.Diff([]string{"label", "metadata", "images"}, fields); diff != "" {
+			t.Errorf("metadataDiff fields mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(map[string]any{
+			"label":    m.Label,
+			"metadata": m.Metadata,
+			"images":   m.Images,
+		}, doc); diff != "" {
+			t.Errorf("metadataDiff doc mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestBookTextRealign(t *testing.T) {
+	t.Parallel()
+
+	newBookText := func(canvasStart int, images []string) *BookText {
+		bt, err := ConvertOCR("ndlocrv3", []OCRInfo{{
+			LocalPath:   filepath.Join(srcDir, "ndlocrv3", "lite-0001"),
+			CanvasStart: canvasStart,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bt.Images = images
+		bt.AlignPages()
+		return bt
+	}
+
+	// the images reordered, of the same count
+	bt := newBookText(0, []string{"a/lite-0001-0001.tif", "a/lite-0001-0002.tif"})
+	m := &IIIFManifest{Images: []string{"a/lite-0001-0002.tif", "a/lite-0001-0001.tif"}}
+	fields, doc := bt.metadataDiff(m)
+	fields, pa := bt.realign(m, fields, doc)
+	if diff := cmp.Diff([]string{"images", "pageCanvases"}, fields); diff != "" {
+		t.Errorf("realign fields mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{1, 0}, doc["pageCanvases"]); diff != "" {
+		t.Errorf("realign pageCanvases mismatch (-want +got):\n%s", diff)
+	}
+	if pa.Method != AlignImageName {
+		t.Errorf("realign method => %s, want %s", pa.Method, AlignImageName)
+	}
+
+	// by canvasStart
+	bt = newBookText(2, []string{"a/0001.tif", "a/0002.tif", "a/0003.tif"})
+	m = &IIIFManifest{Images: []string{"b/0001.tif", "b/0002.tif", "b/0003.tif"}}
+	fields, doc = bt.metadataDiff(m)
+	fields, pa = bt.realign(m, fields, doc)
+	if diff := cmp.Diff([]string{"images"}, fields); diff != "" {
+		t.Errorf("realign fields mismatch (-want +got):\n%s", diff)
+	}
+	if pa.Method != AlignExplicit || !slices.Equal(bt.PageCanvases, []int{1, 2}) {
+		t.Errorf("realign => %s %v, want %s [1 2]", pa.Method, bt.PageCanvases, AlignExplicit)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	AlignExplicit   = "explicit"   // canvasStart given
+	AlignImageName  = "imageName"  // OCR image names matched to canvas images
+	AlignSequential = "sequential" // n-th page to n-th canvas
+)
+
+/* PageAlignment */
+// PageAlignment reports how the OCR pages of a book are mapped to the
+// canvases of its manifest
+type PageAlignment struct {
+	Bid      string `json:"bid"`
+	Method   string `json:"method"`
+	Pages    int    `json:"pages"`
+	Canvases int    `json:"canvases"`
+	// OCR pages (1-origin) without canvases
+	Unmapped []int `json:"unmapped,omitempty"`
+}
+
+// Mismatch reports whether some pages are not mapped, or the page and
+// canvas counts differ without explicit mapping
+func (pa *PageAlignment) Mismatch() bool {
+	return len(pa.Unmapped) > 0 ||
+		(pa.Method == AlignSequential && pa.Pages != pa.Canvases)
+}
+
+func (pa *PageAlignment) String() string {
+	s := fmt.Sprintf("%s: %d pages, %d canvases (%s)",
+		pa.Bid, pa.Pages, pa.Canvases, pa.Method)
+	if len(pa.Unmapped) > 0 {
+		s += fmt.Sprintf("; unmapped pages: %v", pa.Unmapped)
+	}
+	return s
+}
+
+// AlignPages sets PageCanvases, mapping the OCR pages to the canvases
+// by canvasStart if given, by image names if any matches, otherwise in
+// order; it must be called after the manifest is set
+func (bt *BookText) AlignPages() *PageAlignment {
+	pages := len(bt.Pbs)
+	pa := &PageAlignment{
+		Bid:      bt.Bid,
+		Pages:    pages,
+		Canvases: len(bt.Images),
+	}
+
+	switch {
+	case bt.PageCanvases != nil:
+		pa.Method = AlignExplicit
+	case bt.alignByImageName():
+		pa.Method = AlignImageName
+	default:
+		pa.Method = AlignSequential
+		bt.PageCanvases = make([]int, pages)
+		for i := range bt.PageCanvases {
+			bt.PageCanvases[i] = i
+		}
+	}
+
+	bt.PageAlignment = pa.Method
+
+	for i, c := range bt.PageCanvases {
+		if c < 0 || c >= len(bt.Images) {
+			bt.PageCanvases[i] = -1
+			pa.Unmapped = append(pa.Unmapped, i+1)
+		}
+	}
+
+	return pa
+}
+
+// alignByImageName maps pages to the canvases whose image has the same
+// base name (without extension) as the OCR image; false if none matches
+func (bt *BookText) alignByImageName() bool {
+	canvases := map[string]int{}
+	for i, img := range bt.Images {
+		name := imageBaseName(path.Base(img))
+		if _, ok := canvases[name]; ok {
+			// ambiguous
+			canvases[name] = -1
+			continue
+		}
+		canvases[name] = i
+	}
+
+	pcs := make([]int, len(bt.Pbs))
+	matched := false
+	for i := range pcs {
+		pcs[i] = -1
+		if i >= len(bt.OCRImages) || bt.OCRImages[i].Name == "" {
+			continue
+		}
+		name := imageBaseName(filepath.Base(bt.OCRImages[i].Name))
+		if c, ok := canvases[name]; ok && c != -1 {
+			pcs[i] = c
+			matched = true
+		}
+	}
+	if !matched {
+		return false
+	}
+
+	bt.PageCanvases = pcs
+	return true
+}
+
+func imageBaseName(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// PageCanvas returns the canvas index of the page (0-origin); -1 if none
+func (bt *BookText) PageCanvas(page int) int {
+	if bt.PageCanvases == nil {
+		// indexed before alignment
+		if page >= 0 && page < len(bt.Images) {
+			return page
+		}
+		return -1
+	}
+	if page < 0 || page >= len(bt.PageCanvases) {
+		return -1
+	}
+	return bt.PageCanvases[page]
+}
+
+// PageImage returns the image of the canvas of the page; "" if none
+func (bt *BookText) PageImage(page int) string {
+	c := bt.PageCanvas(page)
+	if c < 0 || c >= len(bt.Images) {
+		return ""
+	}
+	return bt.Images[c]
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestBookTextAlignPages(t *testing.T) {
+	t.Parallel()
+
+	t.Run("BookTextAlignPages", func(t *testing.T) {
+		// n-th page to n-th canvas
+		testBookTextAlignPages(t, 0, []string{"a/0001.tif", "a/0002.tif"},
+			AlignSequential, []int{0, 1}, nil, false)
+		// canvases missing
+		testBookTextAlignPages(t, 0, []string{"a/0001.tif"},
+			AlignSequential, []int{0, -1}, []int{2}, true)
+		// canvases more than pages
+		testBookTextAlignPages(t, 0, []string{"a/0001.tif", "a/0002.tif", "a/0003.tif"},
+			AlignSequential, []int{0, 1}, nil, true)
+		// by imginfo.img_name
+		testBookTextAlignPages(t, 0, []string{
+			"a/cover.tif", "a/lite-0001-0001.tif", "a/lite-0001-0002.tif",
+		}, AlignImageName, []int{1, 2}, nil, false)
+		testBookTextAlignPages(t, 0, []string{
+			"a/cover.tif", "a/lite-0001-0002.tif",
+		}, AlignImageName, []int{-1, 1}, []int{1}, true)
+		// by canvasStart
+		testBookTextAlignPages(t, 2, []string{"a/0001.tif", "a/0002.tif", "a/0003.tif"},
+			AlignExplicit, []int{1, 2}, nil, false)
+		testBookTextAlignPages(t, 3, []string{"a/0001.tif", "a/0002.tif", "a/0003.tif"},
+			AlignExplicit, []int{2, -1}, []int{2}, true)
+	})
+}
+
+func testBookTextAlignPages(t *testing.T, canvasStart int, images []string, method string, pcs, unmapped []int, mismatch bool) {
+	t.Helper()
+
+	bt, err := ConvertOCR("ndlocrv3", []OCRInfo{{
+		LocalPath:   filepath.Join(srcDir, "ndlocrv3", "lite-0001"),
+		CanvasStart: canvasStart,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Bid = "lite-0001"
+	bt.Images = images
+
+	pa := bt.AlignPages()
+	if pa.Method != method {
+		t.Errorf("AlignPages(%v).Method => %s, want %s", images, pa.Method, method)
+	}
+	if diff := cmp.Diff(pcs, bt.PageCanvases); diff != "" {
+		t.Errorf("AlignPages(%v).PageCanvases mismatch (-want +got):\n%s", images, diff)
+	}
+	if diff := cmp.Diff(unmapped, pa.Unmapped); diff != "" {
+		t.Errorf("AlignPages(%v).Unmapped mismatch (-want +got):\n%s", images, diff)
+	}
+	if pa.Mismatch() != mismatch {
+		t.Errorf("AlignPages(%v).Mismatch() => %t, want %t", images, pa.Mismatch(), mismatch)
+	}
+}
+
+func TestNewPartialTextWithContextUnmapped(t *testing.T) {
+	t.Parallel()
+
+	bt, err := ConvertOCR("ndlocrv3", []OCRInfo{{
+		LocalPath: filepath.Join(srcDir, "ndlocrv3", "lite-0001"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Bid = "lite-0001"
+	bt.Images = []string{"0001.tif"}
+	bt.AlignPages()
+
+	// lines on the last page, whose canvas is missing
+	text := "久しくとゞまりたる例なし"
+	pwc, err := NewPartialTextWithContext(bt.GetId_(), bt, text, text, [][2]int{{0, 2}}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{""}, pwc.ImageIds); diff != "" {
+		t.Errorf("ImageIds mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{""}, pwc.HitImageIds); diff != "" {
+		t.Errorf("HitImageIds mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -14,16 +14,35 @@ type PartialtextWithContext struct {
 	Text     string   `json:"text"`
 	BBs      []*BB    `json:"bbs"`
 	ImageIds []string `json:"imageIDs"`
+	// lowest known confidence of the lines; 0 if unknown
+	Confidence float64 `json:"conf"`
+	// tight boxes of the highlighted words, one per line
+	HitBBs      []*BB    `json:"hitBBs"`
+	HitImageIds []string `json:"hitImageIDs"`
+	// whether the boxes are scaled to the canvas space
+	Scaled bool `json:"scaled"`
+	// characters substituted in the highlighted words, by variants or □,
+	// and the words substituted or of variant characters
+	Distance int      `json:"distance,omitempty"`
+	Variants []string `json:"variants,omitempty"`
 	Key      string   `json:"-"`
+	// page indices of BBs and HitBBs
+	linePages []int
+	hitPages  []int
 }
 
-func NewPartialTextWithContext(id string, bt *BookText, s, t string) (*PartialtextWithContext, error) {
-	idx := strings.Index(bt.Text, t)
-	if idx == -1 {
-		return nil, fmt.Errorf("partial text not found: id:%s; sourceid:%s; searched:%s", id, bt.Bid, bt.Text[:48])
+// NewPartialTextWithContext locates t (s without highlight tags) in bt at
+// pos (runes), or by the text if pos is -1; spans are [offset, length]
+// (runes) of the highlighted words in t
+func NewPartialTextWithContext(id string, bt *BookText, s, t string, spans [][2]int, pos int) (*PartialtextWithContext, error) {
+	bPos := pos
+	if bPos == -1 {
+		idx := strings.Index(bt.Text, t)
+		if idx == -1 {
+			return nil, fmt.Errorf("partial text not found: id:%s; sourceid:%s; searched:%s", id, bt.Bid, bt.Text[:48])
+		}
+		bPos = len([]rune(bt.Text[:idx]))
 	}
-
-	bPos := len([]rune(bt.Text[:idx]))
 	bPageIdx := sort.Search(len(bt.Pbs),
 		func(i int) bool { return bt.Pbs[i] > bPos }) - 1
 	bPageLineIdx := slices.Index(bt.Lbs, bt.Pbs[bPageIdx]) // != -1
@@ -38,21 +57,78 @@ func NewPartialTextWithContext(id string, bt *BookText, s, t string) (*Partialte
 		func(i int) bool { return bt.Lbs[i] > ePos }) - 1
 
 	imageIds := make([]string, 0, eLineIdx-bLineIdx+1)
+	linePages := make([]int, 0, eLineIdx-bLineIdx+1)
 	p := bPageIdx
 	for i := bLineIdx; i <= eLineIdx; i++ {
-		if bt.Lbs[i] == bt.Pbs[p+1] {
+		for p+1 < len(bt.Pbs) && bt.Lbs[i] >= bt.Pbs[p+1] {
 			p += 1
 		}
-		imageIds = append(imageIds, bt.Images[p])
+		imageIds = append(imageIds, bt.PageImage(p))
+		linePages = append(linePages, p)
+	}
+
+	bbs := bt.BBs[bLineIdx : eLineIdx+1]
+	conf := 0.0
+	for _, bb := range bbs {
+		if bb.Confidence > 0 && (conf == 0 || bb.Confidence < conf) {
+			conf = bb.Confidence
+		}
+	}
+
+	hitBBs := []*BB{}
+	hitImageIds := []string{}
+	hitPages := []int{}
+	for _, span := range spans {
+		bbs, lidxs := bt.SpanBBs(bPos+span[0], span[1])
+		for i, bb := range bbs {
+			pidx := sort.Search(len(bt.Pbs),
+				func(j int) bool { return bt.Pbs[j] > bt.Lbs[lidxs[i]] }) - 1
+			hitBBs = append(hitBBs, bb)
+			hitImageIds = append(hitImageIds, bt.PageImage(pidx))
+			hitPages = append(hitPages, pidx)
+		}
 	}
 
 	return &PartialtextWithContext{
-		Id:       id,
-		Pages:    []int{bPageIdx, ePageIdx},
-		Lines:    []int{bLineIdx - bPageLineIdx, eLineIdx - ePageLineIdx},
-		Text:     s,
-		BBs:      bt.BBs[bLineIdx : eLineIdx+1],
-		ImageIds: imageIds,
-		Key:      fmt.Sprintf("%s_%04d_%04d", id, bPageIdx+1, bLineIdx+1),
+		Id:          id,
+		Pages:       []int{bPageIdx, ePageIdx},
+		Lines:       []int{bLineIdx - bPageLineIdx, eLineIdx - ePageLineIdx},
+		Text:        s,
+		BBs:         bbs,
+		ImageIds:    imageIds,
+		Confidence:  conf,
+		HitBBs:      hitBBs,
+		HitImageIds: hitImageIds,
+		Key:         fmt.Sprintf("%s_%04d_%04d", id, bPageIdx+1, bLineIdx+1),
+		linePages:   linePages,
+		hitPages:    hitPages,
 	}, nil
 }
+
+// ScaleToCanvas scales BBs and HitBBs from the OCR image space to the
+// canvas space of bt; nothing is done unless all the pages have sizes
+func (pwc *PartialtextWithContext) ScaleToCanvas(bt *BookText) {
+	scale := func(bbs []*BB, pages []int) ([]*BB, bool) {
+		scaled := make([]*BB, len(bbs))
+		for i, bb := range bbs {
+			sx, sy, ok := bt.CanvasScale(pages[i])
+			if !ok {
+				return nil, false
+			}
+			scaled[i] = bb.Scale(sx, sy)
+		}
+		return scaled, true
+	}
+
+	bbs, ok := scale(pwc.BBs, pwc.linePages)
+	if !ok {
+		return
+	}
+	hitBBs, ok := scale(pwc.HitBBs, pwc.hitPages)
+	if !ok {
+		return
+	}
+	pwc.BBs = bbs
+	pwc.HitBBs = hitBBs
+	pwc.Scaled = true
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestNewPartialTextWithContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NewPartialTextWithContext", func(t *testing.T) {
+		testNewPartialTextWithContext(t, "うたかたはかつ消え", []int{0, 0}, []int{1, 2}, 0.754)
+		testNewPartialTextWithContext(t, "ゆく河", []int{0, 0}, []int{0, 0}, 0.982)
+	})
+}
+
+func testNewPartialTextWithContext(t *testing.T, text string, pages, lines []int, conf float64) {
+	t.Helper()
+
+	bt, err := ConvertOCR("ndlocrv3", []OCRInfo{{
+		LocalPath: filepath.Join(srcDir, "ndlocrv3", "lite-0001"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Bid = "lite-0001"
+	bt.Images = []string{"0001.tif", "0002.tif"}
+
+	pwc, err := NewPartialTextWithContext(bt.GetId_(), bt, text, text, nil, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(pwc.Pages, pages); diff != "" {
+		t.Errorf("NewPartialTextWithContext(%s).Pages mismatch (-want +got):\n%s", text, diff)
+	}
+	if diff := cmp.Diff(pwc.Lines, lines); diff != "" {
+		t.Errorf("NewPartialTextWithContext(%s).Lines mismatch (-want +got):\n%s", text, diff)
+	}
+	if pwc.Confidence != conf {
+		t.Errorf("NewPartialTextWithContext(%s).Confidence => %g, want %g", text, pwc.Confidence, conf)
+	}
+}
+
+func TestBookTextSpanBBs(t *testing.T) {
+	t.Parallel()
+
+	t.Run("BookTextSpanBBs", func(t *testing.T) {
+		// estimated from a vertical line box (1700,200,100,2400) of 23 chars
+		testBookTextSpanBBs(t, "ndlocrv3", "lite-0001", "河の", []*BB{
+			{X: 1700, Y: 408, Width: 100, Height: 209},
+		})
+		// from the segs of two ALTO Strings
+		testBookTextSpanBBs(t, "alto", "hojoki-0001", "してしか", []*BB{
+			{X: 1700, Y: 1033, Width: 100, Height: 467},
+		})
+		// across lines
+		testBookTextSpanBBs(t, "alto", "hojoki-0001", "あらずよど", []*BB{
+			{X: 1700, Y: 2100, Width: 100, Height: 300},
+			{X: 1400, Y: 200, Width: 100, Height: 363},
+		})
+	})
+}
+
+func testBookTextSpanBBs(t *testing.T, name, dir, text string, expect []*BB) {
+	t.Helper()
+
+	bt, err := ConvertOCR(name, []OCRInfo{{
+		LocalPath: filepath.Join(srcDir, name, dir),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := strings.Index(bt.Text, text)
+	if idx == -1 {
+		t.Fatalf("%s not found", text)
+	}
+	pos := utf8.RuneCountInString(bt.Text[:idx])
+	got, _ := bt.SpanBBs(pos, utf8.RuneCountInString(text))
+
+	if diff := cmp.Diff(got, expect); diff != "" {
+		t.Errorf("(*BookText).SpanBBs(%s) mismatch (-want +got):\n%s", text, diff)
+	}
+}
+
+func TestPartialTextWithContextScaleToCanvas(t *testing.T) {
+	t.Parallel()
+
+	bt, err := ConvertOCR("ndlocrv3", []OCRInfo{{
+		LocalPath: filepath.Join(srcDir, "ndlocrv3", "lite-0001"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Bid = "lite-0001"
+	bt.Images = []string{"0001.tif", "0002.tif"}
+
+	text := "よどみ"
+	pwc, err := NewPartialTextWithContext(bt.GetId_(), bt, text, text, [][2]int{{0, 3}}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no canvas sizes
+	pwc.ScaleToCanvas(bt)
+	if pwc.Scaled {
+		t.Errorf("ScaleToCanvas without canvases => scaled")
+	}
+
+	// OCR images are 2000x3000
+	bt.Canvases = []Canvas{{Width: 1000, Height: 1500}, {Width: 1000, Height: 1500}}
+	pwc.ScaleToCanvas(bt)
+	if !pwc.Scaled {
+		t.Fatalf("ScaleToCanvas => not scaled")
+	}
+	if diff := cmp.Diff(pwc.BBs, []*BB{
+		{X: 700, Y: 100, Width: 50, Height: 1000, Confidence: 0.911},
+	}); diff != "" {
+		t.Errorf("ScaleToCanvas BBs mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(pwc.HitBBs, []*BB{
+		{X: 700, Y: 100, Width: 50, Height: 273},
+	}); diff != "" {
+		t.Errorf("ScaleToCanvas HitBBs mismatch (-want +got):\n%s", diff)
+	}
+	// the stored boxes are kept as they are
+	if bt.BBs[1].Width != 100 {
+		t.Errorf("ScaleToCanvas modified BookText.BBs")
+	}
+}
+
+func TestNewPartialTextWithContextPos(t *testing.T) {
+	t.Parallel()
+
+	// the same line on two pages
+	bt := &BookText{
+		Bid:  "test-0001",
+		Text: "昔男ありけり昔男ありけり",
+		Pbs:  []int{0, 6},
+		Lbs:  []int{0, 6},
+		BBs: []*BB{
+			{X: 100, Y: 100, Width: 50, Height: 600},
+			{X: 100, Y: 100, Width: 50, Height: 600},
+		},
+		Images: []string{"0001.tif", "0002.tif"},
+	}
+
+	text := "ありけり"
+	tests := []struct {
+		pos   int
+		pages []int
+	}{
+		// located by the text, the first
+		{-1, []int{0, 0}},
+		{2, []int{0, 0}},
+		{8, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		pwc, err := NewPartialTextWithContext(bt.GetId_(), bt, text, text, [][2]int{{0, 4}}, tt.pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tt.pages, pwc.Pages); diff != "" {
+			t.Errorf("pos %d: Pages mismatch (-want +got):\n%s", tt.pos, diff)
+		}
+		if diff := cmp.Diff([]string{bt.PageImage(tt.pages[0])}, pwc.HitImageIds); diff != "" {
+			t.Errorf("pos %d: HitImageIds mismatch (-want +got):\n%s", tt.pos, diff)
+		}
+	}
+}
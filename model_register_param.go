@@ -9,9 +9,18 @@ type RegisterParam struct {
 	Cid       string   `form:"cid"`
 	MecabType string   `form:"mecabType"`
 	Iid       string   `form:"iid"`
+	Manifest  string   `form:"manifest"`
 	LocalPath []string `form:"localPath"`
 	StartPos  []int    `form:"startPos"`
 	EndPos    []int    `form:"endPos"`
+	// canvas (1-origin) of the first page of each localPath; optional
+	CanvasStart []int `form:"canvasStart"`
+}
+
+/* RegisterResult */
+type RegisterResult struct {
+	*RegisterParam
+	Alignment *PageAlignment `json:"alignment"`
 }
 
 /* OCRInfo */
@@ -19,6 +28,8 @@ type OCRInfo struct {
 	LocalPath string `form:"localPath"`
 	StartPos  int    `form:"startPos"`
 	EndPos    int    `form:"endPos"`
+	// 0 if not given
+	CanvasStart int `form:"canvasStart"`
 }
 
 func NewOCRInfos(rp *RegisterParam) ([]OCRInfo, error) {
@@ -26,12 +37,18 @@ func NewOCRInfos(rp *RegisterParam) ([]OCRInfo, error) {
 	if len(rp.StartPos) != cnt || len(rp.EndPos) != cnt {
 		return nil, fmt.Errorf("lengths of localPath, startPos and endPos not same")
 	}
+	if len(rp.CanvasStart) != 0 && len(rp.CanvasStart) != cnt {
+		return nil, fmt.Errorf("lengths of localPath and canvasStart not same")
+	}
 	ois := make([]OCRInfo, cnt)
 
 	for i, lp := range rp.LocalPath {
 		ois[i].LocalPath = lp
 		ois[i].StartPos = rp.StartPos[i]
 		ois[i].EndPos = rp.EndPos[i]
+		if len(rp.CanvasStart) != 0 {
+			ois[i].CanvasStart = rp.CanvasStart[i]
+		}
 	}
 
 	return ois, nil
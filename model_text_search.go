@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
@@ -18,8 +19,81 @@ type TextSearchParam struct {
 	ELevels []ELevel `query:"el" form:"el"`
 	Tags    []string `query:"tag" form:"tag"`
 	Bids    []string `query:"bid" form:"bid"`
-	Page    int      `query:"page" form:"query"`
-	PerPage int      `query:"perPage" from:"perPage"`
+	// facet values as filters
+	Licenses     []string `query:"license" form:"license"`
+	Attributions []string `query:"attribution" form:"attribution"`
+	Page         int      `query:"page" form:"query"`
+	PerPage      int      `query:"perPage" from:"perPage"`
+	// matches whose lines are known less confident are dropped
+	MinConfidence float64 `query:"minConf" form:"minConf"`
+	// if true, boxes are scaled to the IIIF canvas space
+	Canvas bool `query:"canvas" form:"canvas"`
+	// if true, □ of the text matches any character of the text terms
+	Unknown bool `query:"unknown" form:"unknown"`
+	// if true, the text terms match the variants in confusionTable
+	Fuzzy bool `query:"fuzzy" form:"fuzzy"`
+	// if true, text.kana is searched; see FoldKana
+	Kana bool `query:"kana" form:"kana"`
+	// "reading" to search the text terms by reading; see SetReading
+	Mode string `query:"mode" form:"mode"`
+	// filters on the metadata fields; see BindBiblioFilters
+	Biblio       map[string][]string
+	BiblioRanges map[string]BiblioRange
+	// Words parsed and ANDed; see ParseQuery
+	Query *QueryNode
+}
+
+// ParseQuery parses Words into Query
+func (sp *TextSearchParam) ParseQuery() error {
+	if len(sp.Words) == 0 {
+		return fmt.Errorf("query missing")
+	}
+	children := []*QueryNode{}
+	for i, w := range sp.Words {
+		n, err := ParseQuery(w)
+		if err != nil {
+			if len(sp.Words) > 1 {
+				return fmt.Errorf("q #%d: %s", i+1, err)
+			}
+			return err
+		}
+		children = append(children, n)
+	}
+	q := children[0]
+	if len(children) > 1 {
+		q = &QueryNode{Op: QueryAnd, Pos: 1, Children: children}
+	}
+	if !q.HasText() {
+		return fmt.Errorf("no text term to search")
+	}
+	if sp.Unknown {
+		q.SetUnknown()
+	}
+	if sp.Fuzzy {
+		q.SetFuzzy()
+	}
+	if sp.Kana {
+		q.SetKana()
+	}
+	switch sp.Mode {
+	case "", "text":
+	case "reading":
+		if sp.Unknown || sp.Fuzzy || sp.Kana {
+			return fmt.Errorf("mode=reading cannot be used with unknown, fuzzy or kana")
+		}
+		if cfg.ReadingMecabType == "" {
+			return fmt.Errorf("mode=reading not available: ReadingMecabType not set")
+		}
+		if err := q.SetReading(func(s string) (string, error) {
+			return MecabReading(cfg.ReadingMecabType, s)
+		}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown mode: %s", sp.Mode)
+	}
+	sp.Query = q
+	return nil
 }
 
 func (sp *TextSearchParam) GetCacheKey() string {
@@ -48,28 +122,53 @@ func (sp *TextSearchParam) GetCacheKey() string {
 		s += "&bid=" + strings.Join(b, ",")
 	}
 
+	if len(sp.Licenses) > 0 {
+		l := append([]string{}, sp.Licenses...)
+		slices.Sort(l)
+		s += "&license=" + strings.Join(l, ",")
+	}
+
+	if len(sp.Attributions) > 0 {
+		a := append([]string{}, sp.Attributions...)
+		slices.Sort(a)
+		s += "&attribution=" + strings.Join(a, ",")
+	}
+
+	s += sp.biblioCacheKey()
+
+	if sp.MinConfidence > 0 {
+		s += fmt.Sprintf("&minConf=%g", sp.MinConfidence)
+	}
+
+	if sp.Canvas {
+		s += "&canvas=true"
+	}
+
+	if sp.Unknown {
+		s += "&unknown=true"
+	}
+
+	if sp.Fuzzy {
+		s += "&fuzzy=true"
+	}
+
+	if sp.Kana {
+		s += "&kana=true"
+	}
+
+	if sp.Mode == "reading" {
+		s += "&mode=reading"
+	}
+
 	return s
 }
 
 func (sp *TextSearchParam) GetESQuery() *types.Query {
-	qw := []types.Query{}
-	for _, w := range sp.Words {
-		qw = append(qw, types.Query{
-			MatchPhrase: map[string]types.MatchPhraseQuery{
-				cfg.IndexName: {
-					Query: w,
-				},
-			},
-		})
-	}
-
 	q := &types.Query{
 		Bool: &types.BoolQuery{
-			Filter: []types.Query{{
-				Bool: &types.BoolQuery{
-					Must: qw,
-				},
-			}},
+			Filter: []types.Query{
+				sp.Query.ESQuery(),
+			},
 		},
 	}
 
@@ -91,7 +190,7 @@ func (sp *TextSearchParam) GetESQuery() *types.Query {
 		q.Bool.Filter = append(q.Bool.Filter, types.Query{
 			Terms: &types.TermsQuery{
 				TermsQuery: map[string]types.TermsQueryField{
-					"tag": sp.Tags,
+					"tags": sp.Tags,
 				},
 			},
 		})
@@ -107,20 +206,47 @@ func (sp *TextSearchParam) GetESQuery() *types.Query {
 		})
 	}
 
+	if len(sp.Licenses) > 0 {
+		q.Bool.Filter = append(q.Bool.Filter, types.Query{
+			Terms: &types.TermsQuery{
+				TermsQuery: map[string]types.TermsQueryField{
+					"license": sp.Licenses,
+				},
+			},
+		})
+	}
+
+	if len(sp.Attributions) > 0 {
+		q.Bool.Filter = append(q.Bool.Filter, types.Query{
+			Terms: &types.TermsQuery{
+				TermsQuery: map[string]types.TermsQueryField{
+					"attribution": sp.Attributions,
+				},
+			},
+		})
+	}
+
+	q.Bool.Filter = append(q.Bool.Filter, sp.biblioQueries()...)
+
 	return q
 }
 
 /* TextSearchResult */
 type TextSearchResult struct {
-	Filters struct {
-		Keyword TextSearchKeywordFilter `json:"keyword"`
-		Tag     []LabelValue            `json:"tag"`
-	} `json:"filters"`
+	Filters *TextSearchFilters        `json:"filters"`
 	Bibl    map[string]*BookMetadata  `json:"bibl"`
 	Matches []*PartialtextWithContext `json:"match"`
 	Page    int                       `json:"page"`
 	PerPage int                       `json:"perPage"`
-	Total   int                       `json:"total"`
+	// matches
+	Total int `json:"total"`
+	// hit books (bids) and documents
+	BookTotal int `json:"bookTotal"`
+	DocTotal  int `json:"docTotal"`
+	// whether hits are more than SearchMaxHits
+	Truncated bool `json:"truncated,omitempty"`
+	// whether the facets count the candidates of wildcards dropped
+	Approximate bool `json:"approximate,omitempty"`
 }
 
 type TextSearchKeywordFilter map[string]map[string]map[string]map[string]int
@@ -128,6 +254,11 @@ type Q2Data struct {
 	Id       string
 	BookText *BookText
 	Match    string
+	// whether Match is known to match, as those of MorphFragments
+	Verified bool
+	// offset (runes) of Match, without the tags, in the text; -1 if
+	// unknown, to be located by the text
+	Pos int
 }
 
 // NewTextSearchResult
@@ -135,10 +266,15 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 	var (
 		bibls   = map[string]*BookMetadata{}
 		kwf     = TextSearchKeywordFilter{}
-		tag     = []LabelValue{}
 		matches = []*PartialtextWithContext{}
 	)
 
+	hasNear := sp.Query != nil && sp.Query.HasNear()
+	hasWildcard := sp.Query != nil && sp.Query.HasWildcard()
+	hasMorph := sp.Query != nil && sp.Query.HasMorph()
+	byReading := sp.Query != nil && sp.Query.ByReading
+	dropped := 0
+
 	var errs []string
 	var wg1 sync.WaitGroup
 	var wg2 sync.WaitGroup
@@ -171,11 +307,20 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 					break
 				}
 
-				if _, ok := bibls[hit.Id_]; !ok {
+				// candidates of wildcards verified
+				if hasWildcard && !sp.Query.MatchesText(bt.Text) {
 					mu.Lock()
-					bibls[hit.Id_] = bt.GetMetadata()
+					dropped++
 					mu.Unlock()
+					continue
+				}
+
+				// read and written by the other workers
+				mu.Lock()
+				if _, ok := bibls[hit.Id_]; !ok {
+					bibls[hit.Id_] = bt.GetMetadata()
 				}
+				mu.Unlock()
 
 				if hit.Id_[:9] != bt.Bid {
 					mu.Lock()
@@ -187,11 +332,41 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 					continue
 				}
 
-				for _, match := range hit.Highlight["text"] {
+				// fragments of the readings are located in the text
+				// by the tokens
+				frags := hit.Highlight[sp.Query.TextField()]
+				if byReading {
+					frags = nil
+					for _, f := range sp.Query.ReadingFragments(&bt, fragmentSize) {
+						q2 <- &Q2Data{
+							Id:       hit.Id_,
+							BookText: &bt,
+							Match:    f.Match,
+							Verified: true,
+							Pos:      f.Pos,
+						}
+					}
+				}
+				if hasWildcard {
+					frags = append(frags, sp.Query.WildcardFragments(bt.Text, fragmentSize)...)
+				}
+				for _, match := range frags {
 					q2 <- &Q2Data{
 						Id:       hit.Id_,
 						BookText: &bt,
 						Match:    match,
+						Pos:      -1,
+					}
+				}
+				if hasMorph {
+					for _, f := range sp.Query.MorphFragments(&bt, fragmentSize) {
+						q2 <- &Q2Data{
+							Id:       hit.Id_,
+							BookText: &bt,
+							Match:    f.Match,
+							Verified: true,
+							Pos:      f.Pos,
+						}
 					}
 				}
 			}
@@ -218,6 +393,8 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 				s := q.Match
 				t := ""
 				offset := 0
+				keys := [][2]string{}
+				spans := [][2]int{}
 
 				for {
 					end := strings.Index(s[offset:], "<em class=\"hlt")
@@ -232,9 +409,52 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 					offset = end + 2
 					end = offset + strings.Index(s[offset:], "</em>")
 					word := s[offset:end]
+					spans = append(spans, [2]int{
+						utf8.RuneCountInString(t), utf8.RuneCountInString(word),
+					})
 					t += word
 					offset = end + 5
+					keys = append(keys, [2]string{key, word})
+				}
+
+				t += s[offset:]
+
+				// fragments of a term of NEAR alone
+				if hasNear && !q.Verified && !sp.Query.MatchesFragment(t) {
+					continue
+				}
+
+				pwc, err := NewPartialTextWithContext(id, bt, s, t, spans, q.Pos)
+				if err != nil {
 					mu.Lock()
+					*errs = append(*errs, err.Error())
+					mu.Unlock()
+					continue
+				}
+
+				if sp.MinConfidence > 0 && pwc.Confidence > 0 &&
+					pwc.Confidence < sp.MinConfidence {
+					continue
+				}
+
+				if sp.Canvas {
+					pwc.ScaleToCanvas(bt)
+				}
+
+				// words substituted for wildcard terms, or variants of
+				// the other terms
+				for _, kw := range keys {
+					d, ok := sp.Query.MatchDistance(kw[0], kw[1])
+					pwc.Distance += d
+					if (d > 0 || !ok && sp.Query.IsVariant(kw[1])) &&
+						!slices.Contains(pwc.Variants, kw[1]) {
+						pwc.Variants = append(pwc.Variants, kw[1])
+					}
+				}
+
+				mu.Lock()
+				for _, kw := range keys {
+					key, word := kw[0], kw[1]
 					if _, ok := kwf[key]; !ok {
 						kwf[key] = map[string]map[string]map[string]int{
 							word: {
@@ -258,18 +478,8 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 					} else {
 						kwf[key][word][elevel][id] += 1
 					}
-					mu.Unlock()
-				}
-
-				t += s[offset:]
-
-				pwc, err := NewPartialTextWithContext(id, bt, s, t)
-				if err != nil {
-					mu.Lock()
-					*errs = append(*errs, err.Error())
-					mu.Unlock()
-					continue
 				}
+				mu.Unlock()
 
 				mu.Lock()
 				*pmatches = append(*pmatches, pwc)
@@ -287,7 +497,11 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 	close(q2)
 	wg2.Wait()
 
+	// closer matches first
 	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
 		return matches[i].Key < matches[j].Key
 	})
 
@@ -295,15 +509,37 @@ func NewTextSearchResult(sp *TextSearchParam, res *search.Response) (*TextSearch
 		return nil, fmt.Errorf(strings.Join(errs, "\n"))
 	}
 
+	filters, err := NewTextSearchFilters(kwf, res.Aggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	bids := map[string]bool{}
+	for _, b := range bibls {
+		bids[b.Bid] = true
+	}
+	docTotal := len(res.Hits.Hits)
+	if res.Hits.Total != nil && int(res.Hits.Total.Value) > docTotal {
+		docTotal = int(res.Hits.Total.Value)
+	}
+	truncated := docTotal > len(res.Hits.Hits)
+	docTotal -= dropped
+
+	// books of the hits not got counted by ES
+	bookTotal := len(bids)
+	if ca, ok := res.Aggregations[bookTotalAgg].(*types.CardinalityAggregate); ok && truncated {
+		bookTotal = max(bookTotal, int(ca.Value))
+	}
+
 	return &TextSearchResult{
-		Filters: struct {
-			Keyword TextSearchKeywordFilter `json:"keyword"`
-			Tag     []LabelValue            `json:"tag"`
-		}{
-			Keyword: kwf,
-			Tag:     tag,
-		},
-		Bibl:    bibls,
-		Matches: matches,
+		Filters:   filters,
+		Bibl:      bibls,
+		Matches:   matches,
+		Total:     len(matches),
+		BookTotal: bookTotal,
+		DocTotal:  docTotal,
+		Truncated: truncated,
+		// the facets are of ES, before the candidates are verified
+		Approximate: dropped > 0,
 	}, nil
 }
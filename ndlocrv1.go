@@ -2,17 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
-	"path/filepath"
-	"sort"
-	"strings"
-	"unicode/utf8"
 )
 
 type NdlOcrV1Line struct {
 	BoundingBox NdlOcrV1BoundingBox `json:"boundingBox"`
-	Confidence  int                 `json:"confidence"`
+	Confidence  float64             `json:"confidence"`
 	ID          int                 `json:"id"`
 	IsTextline  string              `json:"isTextline"`
 	IsVertical  string              `json:"isVertical"`
@@ -25,73 +20,51 @@ type NdlOcrV1Book []NdlOcrV1Page
 
 type NdlOcrV1BoundingBox [4][2]int
 
+func init() {
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "ndlocrv1",
+		Descr:    "NDL kotenseki OCR v1 JSON",
+		Patterns: []string{"**/json/*.json"},
+		Parser:   OCRParserFunc(parseNdlOcrV1File),
+		Sniff:    sniffNdlOcrV1,
+	})
+}
+
 // NdlOcrV12BookText convert ndlkotenocr result to *BookText
 func NdlOcrV12BookText(ocrInfos []OCRInfo) (*BookText, error) {
-	var (
-		sb  strings.Builder
-		lbs []int
-		pbs []int
-		bbs []*BB
-	)
-	pos := 0
-
-	for _, oi := range ocrInfos {
-		files, err := filepath.Glob(filepath.Join(oi.LocalPath, "**/json/*.json"))
-		if err != nil {
-			return nil, err
-		}
-
-		if len(files) == 0 {
-			return nil, fmt.Errorf("no files found under %s", oi.LocalPath)
-		}
-
-		sort.Strings(files)
-		if oi.StartPos <= 1 {
-			if oi.EndPos != 0 && oi.EndPos < len(files) {
-				files = files[:oi.EndPos]
-			}
-		} else if oi.EndPos != 0 && oi.EndPos < len(files) {
-			files = files[oi.StartPos-1 : oi.EndPos]
-		} else {
-			files = files[oi.StartPos-1:]
-		}
+	return ConvertOCR("ndlocrv1", ocrInfos)
+}
 
-		for _, file := range files {
-			raw, err := os.ReadFile(file)
-			if err != nil {
-				return nil, err
-			}
+// sniffNdlOcrV1 detects [[{"boundingBox": .., "text": ..}, ...], ...]
+func sniffNdlOcrV1(raw []byte) bool {
+	return sniffIsObjectLine(sniffFirstLine(sniffJSON(raw)))
+}
 
-			var book NdlOcrV1Book
-			if err := json.Unmarshal(raw, &book); err != nil {
-				return nil, err
-			}
+func parseNdlOcrV1File(b *BookTextBuilder, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
 
-			for _, page := range book {
-				pbs = append(pbs, pos)
-				for _, line := range page {
-					sb.WriteString(line.Text)
-					lbs = append(lbs, pos)
-					pos += utf8.RuneCountInString(line.Text)
-					x := line.BoundingBox[0][0]
-					y := line.BoundingBox[0][1]
-					bbs = append(bbs, &BB{
-						X:      x,
-						Y:      y,
-						Width:  line.BoundingBox[3][0] - x,
-						Height: line.BoundingBox[3][1] - y,
-					})
-				}
-			}
-		}
+	var book NdlOcrV1Book
+	if err := json.Unmarshal(raw, &book); err != nil {
+		return err
 	}
 
-	bt := &BookText{
-		Text: sb.String(),
-		Pbs:  pbs,
-		Lbs:  lbs,
-		BBs:  bbs,
+	for _, page := range book {
+		b.AddPage()
+		for _, line := range page {
+			x := line.BoundingBox[0][0]
+			y := line.BoundingBox[0][1]
+			b.AddLine(line.Text, &BB{
+				X:          x,
+				Y:          y,
+				Width:      line.BoundingBox[3][0] - x,
+				Height:     line.BoundingBox[3][1] - y,
+				Confidence: line.Confidence,
+			})
+		}
 	}
 
-	return bt, nil
+	return nil
 }
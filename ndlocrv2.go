@@ -4,10 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
-	"sort"
-	"strings"
-	"unicode/utf8"
 )
 
 type NdlOcrV2Line [5]interface{}
@@ -28,89 +24,122 @@ type NdlOcrV2BookDetail struct {
 	ImgInfo  NdlOcrV2ImgInfo `json:"imginfo"`
 }
 
+func init() {
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "ndlocrv2",
+		Descr:    "NDL kotenseki OCR v2 JSON",
+		Patterns: []string{"**/json/*.json"},
+		Parser:   ndlOcrV2Parser{isDetail: false},
+		Sniff:    sniffNdlOcrV2,
+	})
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "ndlocrv2detail",
+		Descr:    "NDL kotenseki OCR v2 JSON with imginfo",
+		Patterns: []string{"**/json/*.json"},
+		Parser:   ndlOcrV2Parser{isDetail: true},
+		Sniff:    sniffNdlOcrV2Detail,
+	})
+}
+
 // OCRResult2BookText convert ndlkotenocr result to *BookText
 func NdlOcrV22BookText(ocrInfos []OCRInfo, isDetail bool) (*BookText, error) {
+	if isDetail {
+		return ConvertOCR("ndlocrv2detail", ocrInfos)
+	}
+	return ConvertOCR("ndlocrv2", ocrInfos)
+}
+
+// sniffNdlOcrV2 detects [[[x0, y0, x1, y1, text], ...], ...]
+func sniffNdlOcrV2(raw []byte) bool {
+	return sniffIsTuple(sniffFirstLine(sniffJSON(raw)))
+}
+
+// sniffNdlOcrV2Detail detects {"contents": [[x0, y0, x1, y1, text], ...], "imginfo": ..}
+func sniffNdlOcrV2Detail(raw []byte) bool {
+	o, ok := sniffJSON(raw).(map[string]any)
+	if !ok {
+		return false
+	}
+	return sniffIsTuple(sniffFirstLine([]any{o["contents"]}))
+}
+
+type ndlOcrV2Parser struct {
+	isDetail bool
+}
+
+func (p ndlOcrV2Parser) layout() string {
+	if p.isDetail {
+		return "ndlocrv2detail"
+	}
+	return "ndlocrv2"
+}
+
+func (p ndlOcrV2Parser) Parse(b *BookTextBuilder, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
 	var (
-		sb  strings.Builder
-		pbs []int
-		lbs []int
-		bbs []*BB
+		contents NdlOcrV2Book
+		imgInfo  *NdlOcrV2ImgInfo
 	)
-	pos := 0
 
-	for _, oi := range ocrInfos {
-		files, err := filepath.Glob(filepath.Join(oi.LocalPath, "**/json/*.json"))
-		if err != nil {
-			return nil, err
+	if p.isDetail {
+		var book NdlOcrV2BookDetail
+		if err := json.Unmarshal(raw, &book); err != nil {
+			return fmt.Errorf("not %s layout: %s", p.layout(), err)
 		}
-
-		if len(files) == 0 {
-			return nil, fmt.Errorf("no files found under %s", oi.LocalPath)
+		contents = NdlOcrV2Book{
+			book.Contents,
 		}
-
-		sort.Strings(files)
-		if oi.StartPos <= 1 {
-			if oi.EndPos != 0 && oi.EndPos < len(files) {
-				files = files[:oi.EndPos]
-			}
-		} else if oi.EndPos != 0 && oi.EndPos < len(files) {
-			files = files[oi.StartPos-1 : oi.EndPos]
-		} else {
-			files = files[oi.StartPos-1:]
+		imgInfo = &book.ImgInfo
+	} else {
+		if err := json.Unmarshal(raw, &contents); err != nil {
+			return fmt.Errorf("not %s layout: %s", p.layout(), err)
 		}
+	}
 
-		for _, file := range files {
-			raw, err := os.ReadFile(file)
+	for i, page := range contents {
+		b.AddPage()
+		if imgInfo != nil {
+			b.SetPageImage(imgInfo.ImgName, imgInfo.ImgWidth, imgInfo.ImgHeight)
+		}
+		for j, line := range page {
+			if len(line) != 5 {
+				continue
+			}
+			text, bb, err := line.Parse()
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("not %s layout: page %d line %d: %s",
+					p.layout(), i+1, j+1, err)
 			}
+			b.AddLine(text, bb)
+		}
+	}
 
-			var contents NdlOcrV2Book
-
-			if isDetail {
-				var book NdlOcrV2BookDetail
-				if err := json.Unmarshal(raw, &book); err != nil {
-					return nil, err
-				}
-				contents = NdlOcrV2Book{
-					book.Contents,
-				}
-			} else {
-				if err := json.Unmarshal(raw, &contents); err != nil {
-					return nil, err
-				}
-			}
+	return nil
+}
 
-			for _, page := range contents {
-				pbs = append(pbs, pos)
-				for _, line := range page {
-					if len(line) != 5 {
-						continue
-					}
-					sb.WriteString(line[4].(string))
-					lbs = append(lbs, pos)
-					pos += utf8.RuneCountInString(line[4].(string))
-					x := int(line[0].(float64))
-					y := int(line[1].(float64))
-					w := int(line[2].(float64)) - x
-					h := int(line[3].(float64)) - y
-					bbs = append(bbs, &BB{
-						X:      x,
-						Y:      y,
-						Width:  w,
-						Height: h,
-					})
-				}
-			}
+// Parse converts [x0, y0, x1, y1, text] to the text and *BB
+func (line NdlOcrV2Line) Parse() (string, *BB, error) {
+	var v [4]int
+	for i := 0; i < 4; i++ {
+		f, ok := line[i].(float64)
+		if !ok {
+			return "", nil, fmt.Errorf("coordinate expected, got %T", line[i])
 		}
+		v[i] = int(f)
 	}
-
-	bt := &BookText{
-		Text: sb.String(),
-		Pbs:  pbs,
-		Lbs:  lbs,
-		BBs:  bbs,
+	text, ok := line[4].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("text expected, got %T", line[4])
 	}
 
-	return bt, nil
+	return text, &BB{
+		X:      v[0],
+		Y:      v[1],
+		Width:  v[2] - v[0],
+		Height: v[3] - v[1],
+	}, nil
 }
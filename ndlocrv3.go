@@ -1,11 +1,224 @@
 package main
 
-type NdlOcrV3Line = NdlOcrV2Line
-type NdlOcrV3Page = NdlOcrV2Page
-type NdlOcrV3Book = NdlOcrV2Book
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+/* json */
+
+type NdlOcrV3Line struct {
+	BoundingBox NdlOcrV3BoundingBox `json:"boundingBox"`
+	ID          int                 `json:"id"`
+	IsVertical  string              `json:"isVertical"`
+	IsTextline  string              `json:"isTextline"`
+	Text        *string             `json:"text"`
+	Confidence  float64             `json:"confidence"`
+}
+
+type NdlOcrV3Page []NdlOcrV3Line
+
+type NdlOcrV3BoundingBox [4][2]float64
+
 type NdlOcrV3ImgInfo = NdlOcrV2ImgInfo
-type NdlOcrV3BookDetail = NdlOcrV2BookDetail
+
+type NdlOcrV3BookDetail struct {
+	Contents []NdlOcrV3Page  `json:"contents"`
+	ImgInfo  NdlOcrV3ImgInfo `json:"imginfo"`
+}
+
+/* xml */
+
+type NdlOcrV3XMLDataset struct {
+	XMLName xml.Name          `xml:"OCRDATASET"`
+	Pages   []NdlOcrV3XMLPage `xml:"PAGE"`
+}
+
+type NdlOcrV3XMLPage struct {
+	ImageName string             `xml:"IMAGENAME,attr"`
+	Width     int                `xml:"WIDTH,attr"`
+	Height    int                `xml:"HEIGHT,attr"`
+	Lines     []NdlOcrV3XMLLine  `xml:"LINE"`
+	Blocks    []NdlOcrV3XMLBlock `xml:"TEXTBLOCK"`
+}
+
+type NdlOcrV3XMLBlock struct {
+	Type  string            `xml:"TYPE,attr"`
+	Lines []NdlOcrV3XMLLine `xml:"LINE"`
+}
+
+type NdlOcrV3XMLLine struct {
+	Type   string  `xml:"TYPE,attr"`
+	X      float64 `xml:"X,attr"`
+	Y      float64 `xml:"Y,attr"`
+	Width  float64 `xml:"WIDTH,attr"`
+	Height float64 `xml:"HEIGHT,attr"`
+	Conf   float64 `xml:"CONF,attr"`
+	Order  *int    `xml:"ORDER,attr"`
+	String *string `xml:"STRING,attr"`
+}
+
+func init() {
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "ndlocrv3",
+		Descr:    "NDL OCR v3 (ndlocr-lite, ndlkotenocr-lite) JSON",
+		Patterns: []string{"*.json", "json/*.json", "**/json/*.json"},
+		Excludes: []string{"opt.json"},
+		Parser:   OCRParserFunc(parseNdlOcrV3File),
+		Sniff:    sniffNdlOcrV3,
+		// ndlocr-lite writes both JSON and XML; JSON has imginfo
+		SniffPriority: 1,
+	})
+	// kept for the scripts using the former name; v3 JSON always has imginfo
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "ndlocrv3detail",
+		Descr:    "alias of ndlocrv3",
+		Patterns: []string{"*.json", "json/*.json", "**/json/*.json"},
+		Excludes: []string{"opt.json"},
+		Parser:   OCRParserFunc(parseNdlOcrV3File),
+	})
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "ndlocrv3xml",
+		Descr:    "NDL OCR v3 (ndlocr-lite, ndlkotenocr-lite) XML",
+		Patterns: []string{"*.xml", "xml/*.xml", "**/xml/*.xml"},
+		Parser:   OCRParserFunc(parseNdlOcrV3XMLFile),
+		Sniff:    sniffNdlOcrV3XML,
+	})
+}
 
 func NdlOcrV32BookText(ocrInfos []OCRInfo, isDetail bool) (*BookText, error) {
-	return NdlOcrV22BookText(ocrInfos, isDetail)
+	if isDetail {
+		return ConvertOCR("ndlocrv3detail", ocrInfos)
+	}
+	return ConvertOCR("ndlocrv3", ocrInfos)
+}
+
+// sniffNdlOcrV3 detects {"contents": [[{"boundingBox": .., "text": ..}, ...]], "imginfo": ..}
+func sniffNdlOcrV3(raw []byte) bool {
+	o, ok := sniffJSON(raw).(map[string]any)
+	if !ok {
+		return false
+	}
+	return sniffIsObjectLine(sniffFirstLine(o["contents"]))
+}
+
+func sniffNdlOcrV3XML(raw []byte) bool {
+	return sniffXMLRoot(raw) == "OCRDATASET"
+}
+
+func parseNdlOcrV3File(b *BookTextBuilder, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var book NdlOcrV3BookDetail
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		// contents only
+		if err := json.Unmarshal(raw, &book.Contents); err != nil {
+			return fmt.Errorf("not ndlocrv3 layout: %s", err)
+		}
+	} else if err := json.Unmarshal(raw, &book); err != nil {
+		return fmt.Errorf("not ndlocrv3 layout: %s", err)
+	}
+
+	for i, page := range book.Contents {
+		b.AddPage()
+		if book.ImgInfo.ImgWidth > 0 {
+			b.SetPageImage(book.ImgInfo.ImgName,
+				book.ImgInfo.ImgWidth, book.ImgInfo.ImgHeight)
+		}
+		// lines are numbered by id in reading order
+		lines := append(NdlOcrV3Page{}, page...)
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].ID < lines[j].ID
+		})
+		for j, line := range lines {
+			if line.Text == nil {
+				return fmt.Errorf(
+					"not ndlocrv3 layout: page %d line %d: text missing", i+1, j+1)
+			}
+			x := line.BoundingBox[0][0]
+			y := line.BoundingBox[0][1]
+			b.AddLine(*line.Text, &BB{
+				X:          int(math.Round(x)),
+				Y:          int(math.Round(y)),
+				Width:      int(math.Round(line.BoundingBox[3][0] - x)),
+				Height:     int(math.Round(line.BoundingBox[3][1] - y)),
+				Confidence: line.Confidence,
+			})
+		}
+	}
+
+	return nil
+}
+
+func parseNdlOcrV3XMLFile(b *BookTextBuilder, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var ds NdlOcrV3XMLDataset
+	if err := xml.Unmarshal(raw, &ds); err != nil {
+		return fmt.Errorf("not ndlocrv3xml layout: %s", err)
+	}
+
+	for i, page := range ds.Pages {
+		b.AddPage()
+		if page.Width > 0 {
+			b.SetPageImage(page.ImageName, page.Width, page.Height)
+		}
+		for j, line := range page.SortedLines() {
+			if line.String == nil {
+				return fmt.Errorf(
+					"not ndlocrv3xml layout: page %d line %d: STRING missing", i+1, j+1)
+			}
+			b.AddLine(*line.String, &BB{
+				X:          int(math.Round(line.X)),
+				Y:          int(math.Round(line.Y)),
+				Width:      int(math.Round(line.Width)),
+				Height:     int(math.Round(line.Height)),
+				Confidence: line.Conf,
+			})
+		}
+	}
+
+	return nil
+}
+
+// SortedLines returns lines of the page including those in TEXTBLOCKs,
+// sorted by ORDER if given, in document order otherwise
+func (p *NdlOcrV3XMLPage) SortedLines() []NdlOcrV3XMLLine {
+	lines := append([]NdlOcrV3XMLLine{}, p.Lines...)
+	for _, block := range p.Blocks {
+		lines = append(lines, block.Lines...)
+	}
+	// lines without ORDER keep their document position
+	keys := make([]int, len(lines))
+	for i, l := range lines {
+		keys[i] = i
+		if l.Order != nil {
+			keys[i] = *l.Order
+		}
+	}
+	sort.Stable(ndlOcrV3XMLLines{lines, keys})
+	return lines
+}
+
+type ndlOcrV3XMLLines struct {
+	lines []NdlOcrV3XMLLine
+	keys  []int
+}
+
+func (l ndlOcrV3XMLLines) Len() int           { return len(l.lines) }
+func (l ndlOcrV3XMLLines) Less(i, j int) bool { return l.keys[i] < l.keys[j] }
+func (l ndlOcrV3XMLLines) Swap(i, j int) {
+	l.lines[i], l.lines[j] = l.lines[j], l.lines[i]
+	l.keys[i], l.keys[j] = l.keys[j], l.keys[i]
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestNdlOcrV3XML2BookText(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NdlOcrV3XML2BookText", func(t *testing.T) {
+		testNdlOcrV3XML2BookText(t, "lite-0001")
+	})
+}
+
+// the XML output should be converted the same as the JSON one
+func testNdlOcrV3XML2BookText(t *testing.T, dir string) {
+	t.Helper()
+
+	ois := []OCRInfo{{
+		LocalPath: filepath.Join(srcDir, "ndlocrv3", dir),
+	}}
+	got, err := ConvertOCR("ndlocrv3xml", ois)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ConvertOCR("ndlocrv3", ois)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&got, &expect); diff != "" {
+		t.Errorf("ConvertOCR(ndlocrv3xml, %s) mismatch (-want +got):\n%s", dir, diff)
+	}
+}
+
+func TestNdlOcrLayoutMismatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NdlOcrLayoutMismatch", func(t *testing.T) {
+		testNdlOcrLayoutMismatch(t, "ndlocrv3",
+			"ndlocrv2detail/0001-000101/0001-000101/json/0001-000101-0001.json")
+		testNdlOcrLayoutMismatch(t, "ndlocrv3xml",
+			"pagexml/tkb-0001/page/0001.xml")
+		testNdlOcrLayoutMismatch(t, "ndlocrv2",
+			"ndlocrv3/lite-0001/lite-0001-0001.json")
+		testNdlOcrLayoutMismatch(t, "ndlocrv2detail",
+			"ndlocrv3/lite-0001/lite-0001-0001.json")
+	})
+}
+
+func testNdlOcrLayoutMismatch(t *testing.T, name, file string) {
+	t.Helper()
+
+	f, err := GetOCRFormat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Parser.Parse(&BookTextBuilder{}, filepath.Join(srcDir, file))
+	if err == nil || !strings.Contains(err.Error(), "not "+name+" layout") {
+		t.Errorf("(%s).Parse(%s) => %v, want layout error", name, file, err)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+/* OCRParser */
+// OCRParser parses one OCR result file and appends its pages and lines
+type OCRParser interface {
+	Parse(b *BookTextBuilder, file string) error
+}
+
+// OCRParserFunc adapts an ordinary function to OCRParser
+type OCRParserFunc func(b *BookTextBuilder, file string) error
+
+func (f OCRParserFunc) Parse(b *BookTextBuilder, file string) error {
+	return f(b, file)
+}
+
+/* OCRFormat */
+type OCRFormat struct {
+	Name     string    `json:"name"`
+	Descr    string    `json:"descr"`
+	Patterns []string  `json:"patterns"`
+	Excludes []string  `json:"excludes,omitempty"`
+	Parser   OCRParser `json:"-"`
+	// Sniff reports whether the content of a file is surely of this
+	// format; formats without Sniff are never detected by "auto"
+	Sniff func(raw []byte) bool `json:"-"`
+	// formats of higher SniffPriority win when several are detected,
+	// e.g. JSON over XML written by the same OCR engine
+	SniffPriority int `json:"-"`
+}
+
+// OCRFormatAuto is the register type to detect the format
+const OCRFormatAuto = "auto"
+
+// max number of files sniffed per format
+const sniffFileNum = 10
+
+var (
+	ocrFormats   = map[string]*OCRFormat{}
+	ocrFormatsMu sync.RWMutex
+)
+
+// RegisterOCRFormat makes an OCR format available as a register type
+func RegisterOCRFormat(f *OCRFormat) {
+	ocrFormatsMu.Lock()
+	defer ocrFormatsMu.Unlock()
+
+	if f.Name == "" || f.Parser == nil || len(f.Patterns) == 0 {
+		panic("RegisterOCRFormat: name, patterns and parser required")
+	}
+	if _, ok := ocrFormats[f.Name]; ok {
+		panic("RegisterOCRFormat: duplicated name: " + f.Name)
+	}
+	ocrFormats[f.Name] = f
+}
+
+// GetOCRFormat returns the registered format named name
+func GetOCRFormat(name string) (*OCRFormat, error) {
+	ocrFormatsMu.RLock()
+	defer ocrFormatsMu.RUnlock()
+
+	f, ok := ocrFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("wrong type: %s", name)
+	}
+	return f, nil
+}
+
+// OCRFormats returns all the registered formats sorted by name
+func OCRFormats() []*OCRFormat {
+	ocrFormatsMu.RLock()
+	defer ocrFormatsMu.RUnlock()
+
+	fs := make([]*OCRFormat, 0, len(ocrFormats))
+	for _, f := range ocrFormats {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Name < fs[j].Name
+	})
+	return fs
+}
+
+// DetectOCRFormat sniffs files under ocrInfos and returns the only
+// format matched
+func DetectOCRFormat(ocrInfos []OCRInfo) (*OCRFormat, error) {
+	if len(ocrInfos) == 0 {
+		return nil, fmt.Errorf("no localPath to detect the format")
+	}
+
+	found := []*OCRFormat{}
+	for _, f := range OCRFormats() {
+		if f.Sniff == nil || !f.detect(ocrInfos[0]) {
+			continue
+		}
+		if len(found) > 0 && f.SniffPriority != found[0].SniffPriority {
+			if f.SniffPriority < found[0].SniffPriority {
+				continue
+			}
+			found = found[:0]
+		}
+		found = append(found, f)
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("format not detected under %s",
+			ocrInfos[0].LocalPath)
+	case 1:
+		return found[0], nil
+	default:
+		names := make([]string, len(found))
+		for i, f := range found {
+			names[i] = f.Name
+		}
+		return nil, fmt.Errorf("format ambiguous under %s: %s",
+			ocrInfos[0].LocalPath, strings.Join(names, ", "))
+	}
+}
+
+func (f *OCRFormat) detect(oi OCRInfo) bool {
+	files, err := f.FindFiles(OCRInfo{LocalPath: oi.LocalPath})
+	if err != nil {
+		return false
+	}
+	if len(files) > sniffFileNum {
+		files = files[:sniffFileNum]
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if f.Sniff(raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindFiles lists files of oi matching the patterns but not the
+// excludes (matched against base names), sorted and sliced by
+// StartPos/EndPos (1-origin, inclusive; 0 means unlimited)
+func (f *OCRFormat) FindFiles(oi OCRInfo) ([]string, error) {
+	files := []string{}
+	for _, p := range f.Patterns {
+		matches, err := filepath.Glob(filepath.Join(oi.LocalPath, p))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			excluded, err := f.isExcluded(m)
+			if err != nil {
+				return nil, err
+			}
+			if !excluded {
+				files = append(files, m)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found under %s", oi.LocalPath)
+	}
+
+	sort.Strings(files)
+	files = slices.Compact(files)
+	if oi.StartPos <= 1 {
+		if oi.EndPos != 0 && oi.EndPos < len(files) {
+			files = files[:oi.EndPos]
+		}
+	} else if oi.EndPos != 0 && oi.EndPos < len(files) {
+		files = files[oi.StartPos-1 : oi.EndPos]
+	} else {
+		files = files[oi.StartPos-1:]
+	}
+
+	return files, nil
+}
+
+func (f *OCRFormat) isExcluded(file string) (bool, error) {
+	for _, e := range f.Excludes {
+		ok, err := filepath.Match(e, filepath.Base(file))
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Convert parses all the files of ocrInfos into a *BookText
+func (f *OCRFormat) Convert(ocrInfos []OCRInfo) (*BookText, error) {
+	b := &BookTextBuilder{}
+
+	for _, oi := range ocrInfos {
+		files, err := f.FindFiles(oi)
+		if err != nil {
+			return nil, err
+		}
+
+		from := len(b.pbs)
+		for _, file := range files {
+			if err := f.Parser.Parse(b, file); err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+		if oi.CanvasStart > 0 {
+			b.SetPageCanvases(from, oi.CanvasStart-1)
+		}
+	}
+
+	return b.BookText(), nil
+}
+
+// ConvertOCR converts ocrInfos with the format registered as name
+func ConvertOCR(name string, ocrInfos []OCRInfo) (*BookText, error) {
+	f, err := GetOCRFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Convert(ocrInfos)
+}
+
+/* sniffing helpers */
+
+// sniffJSON decodes raw as JSON; nil if not JSON
+func sniffJSON(raw []byte) any {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// sniffFirstLine returns the first line of the first non-empty page
+// of [[line, ...], ...]
+func sniffFirstLine(v any) any {
+	pages, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+	for _, page := range pages {
+		lines, ok := page.([]any)
+		if !ok {
+			return nil
+		}
+		if len(lines) > 0 {
+			return lines[0]
+		}
+	}
+	return nil
+}
+
+// sniffIsTuple reports whether line is [x0, y0, x1, y1, text]
+func sniffIsTuple(line any) bool {
+	t, ok := line.([]any)
+	if !ok || len(t) != 5 {
+		return false
+	}
+	_, ok = t[4].(string)
+	return ok
+}
+
+// sniffIsObjectLine reports whether line is {"boundingBox": .., "text": ..}
+func sniffIsObjectLine(line any) bool {
+	o, ok := line.(map[string]any)
+	if !ok {
+		return false
+	}
+	_, hasBB := o["boundingBox"]
+	_, hasText := o["text"]
+	return hasBB && hasText
+}
+
+// sniffXMLRoot returns the local name of the root element; "" if not XML
+func sniffXMLRoot(raw []byte) string {
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local
+		}
+	}
+}
+
+/* BookTextBuilder */
+// BookTextBuilder accumulates pages and lines of OCR results
+type BookTextBuilder struct {
+	sb   strings.Builder
+	pos  int
+	pbs  []int
+	lbs  []int
+	bbs  []*BB
+	segs []*Seg
+	imgs []OCRImage
+	// whether any page image is given
+	hasImg bool
+	// canvas of each page; nil if no page is mapped explicitly
+	pcs []int
+}
+
+// AddPage starts a new page
+func (b *BookTextBuilder) AddPage() {
+	b.pbs = append(b.pbs, b.pos)
+	b.imgs = append(b.imgs, OCRImage{})
+}
+
+// SetPageImage sets the OCR input image of the current page
+func (b *BookTextBuilder) SetPageImage(name string, width, height int) {
+	if len(b.pbs) == 0 {
+		b.AddPage()
+	}
+	b.imgs[len(b.imgs)-1] = OCRImage{
+		Name:   name,
+		Width:  width,
+		Height: height,
+	}
+	b.hasImg = true
+}
+
+// SetPageCanvases maps the pages from the page index from (0-origin)
+// to the canvases from canvas (0-origin)
+func (b *BookTextBuilder) SetPageCanvases(from, canvas int) {
+	for len(b.pcs) < len(b.pbs) {
+		b.pcs = append(b.pcs, -1)
+	}
+	for i := from; i < len(b.pcs); i++ {
+		b.pcs[i] = canvas + i - from
+	}
+}
+
+// AddLine appends a line to the current page, starting the first page
+// if none yet; Pos of segs are offsets in the line
+func (b *BookTextBuilder) AddLine(text string, bb *BB, segs ...*Seg) {
+	if len(b.pbs) == 0 {
+		b.AddPage()
+	}
+	for _, seg := range segs {
+		seg.Pos += b.pos
+		b.segs = append(b.segs, seg)
+	}
+	b.sb.WriteString(text)
+	b.lbs = append(b.lbs, b.pos)
+	b.pos += utf8.RuneCountInString(text)
+	b.bbs = append(b.bbs, bb)
+}
+
+// BookText returns the accumulated *BookText
+func (b *BookTextBuilder) BookText() *BookText {
+	bt := &BookText{
+		Text: b.sb.String(),
+		Pbs:  b.pbs,
+		Lbs:  b.lbs,
+		BBs:  b.bbs,
+		Segs: b.segs,
+	}
+	if b.hasImg {
+		bt.OCRImages = b.imgs
+	}
+	if b.pcs != nil {
+		for len(b.pcs) < len(b.pbs) {
+			b.pcs = append(b.pcs, -1)
+		}
+		bt.PageCanvases = b.pcs
+	}
+	return bt
+}
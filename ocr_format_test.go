@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestOCRFormatConvert(t *testing.T) {
+	t.Parallel()
+
+	for _, f := range OCRFormats() {
+		files, err := filepath.Glob(filepath.Join(expectDir, f.Name, "*.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			dir := strings.TrimSuffix(filepath.Base(file), ".json")
+			t.Run(f.Name+"/"+dir, func(t *testing.T) {
+				testOCRFormatConvert(t, f, dir)
+			})
+		}
+	}
+}
+
+func testOCRFormatConvert(t *testing.T, f *OCRFormat, dir string) {
+	t.Helper()
+
+	bt, err := f.Convert([]OCRInfo{{
+		LocalPath: filepath.Join(srcDir, f.Name, dir),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(expectDir, f.Name, dir) + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expect *BookText
+	if err := json.Unmarshal(raw, &expect); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&bt, &expect); diff != "" {
+		t.Errorf("(*OCRFormat).Convert(%s) mismatch (-want +got):\n%s", dir, diff)
+	}
+}
+
+func TestGetOCRFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"ndlocrv1", "ndlocrv2", "ndlocrv2detail", "alto", "hocr", "pagexml"} {
+		if _, err := GetOCRFormat(name); err != nil {
+			t.Errorf("GetOCRFormat(%s): %s", name, err)
+		}
+	}
+	if _, err := GetOCRFormat("unknown"); err == nil {
+		t.Errorf("GetOCRFormat(unknown): error expected")
+	}
+}
+
+func TestDetectOCRFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, f := range OCRFormats() {
+		if f.Sniff == nil {
+			continue
+		}
+		dirs, err := filepath.Glob(filepath.Join(srcDir, f.Name, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range dirs {
+			got, err := DetectOCRFormat([]OCRInfo{{LocalPath: dir}})
+			if err != nil {
+				t.Errorf("DetectOCRFormat(%s): %s", dir, err)
+				continue
+			}
+			if got.Name != f.Name {
+				t.Errorf("DetectOCRFormat(%s) => %s, want %s", dir, got.Name, f.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// Iteration marks (odoriji) expanded into the characters they repeat:
+// ゝ/ヽ and ゞ/ヾ after kana (unvoiced and voiced), 々 after kanji, and
+// 〱 or 〳〵 after two characters. A mark after another mark is left as is,
+// as the char filters of the text field do.
+
+// voiced kana of the unvoiced and the reverse
+var (
+	voicedKana   = map[rune]rune{}
+	unvoicedKana = map[rune]rune{}
+)
+
+func init() {
+	voiced := []rune("がぎぐげござじずぜぞだぢづでどばびぶべぼゔぱぴぷぺぽ")
+	unvoiced := []rune("かきくけこさしすせそたちつてとはひふへほうはひふへほ")
+	for i, r := range unvoiced {
+		for _, d := range []rune{0, 'ァ' - 'ぁ'} {
+			if _, ok := voicedKana[r+d]; !ok {
+				voicedKana[r+d] = voiced[i] + d
+			}
+			unvoicedKana[voiced[i]+d] = r + d
+		}
+	}
+}
+
+func isKana(r rune) bool {
+	return r >= 'ぁ' && r <= 'ゖ' || r >= 'ァ' && r <= 'ヺ'
+}
+
+// expandKanaMark returns the kana which mark after prev stands for; 0 if
+// not an iteration mark of kana
+func expandKanaMark(prev, mark rune) rune {
+	if !isKana(prev) {
+		return 0
+	}
+	unvoiced := prev
+	if u, ok := unvoicedKana[prev]; ok {
+		unvoiced = u
+	}
+	switch mark {
+	case 'ゝ', 'ヽ':
+		return unvoiced
+	case 'ゞ', 'ヾ':
+		if v, ok := voicedKana[unvoiced]; ok {
+			return v
+		}
+		return prev
+	}
+	return 0
+}
+
+// ExpandOdoriji expands the iteration marks of s; src[i] is the byte
+// range in s of the character which the i-th byte of the result, at a
+// character boundary, comes from
+func ExpandOdoriji(s string) (string, [][2]int) {
+	rs := []rune(s)
+	offs := make([]int, len(rs)+1)
+	for i, r := range rs {
+		offs[i+1] = offs[i] + utf8.RuneLen(r)
+	}
+
+	// marks of kana and 々, which keep the length
+	inter := make([]rune, len(rs))
+	for i, r := range rs {
+		inter[i] = r
+		if i == 0 {
+			continue
+		}
+		if x := expandKanaMark(rs[i-1], r); x != 0 {
+			inter[i] = x
+		} else if r == '々' && rs[i-1] != '々' && unicode.Is(unicode.Han, rs[i-1]) {
+			inter[i] = rs[i-1]
+		}
+	}
+
+	// marks of two characters, not overlapping as a regexp replaces
+	var sb strings.Builder
+	src := make([][2]int, 0, len(s))
+	emit := func(r rune, b, e int) {
+		n, _ := sb.WriteRune(r)
+		for i := 0; i < n; i++ {
+			src = append(src, [2]int{b, e})
+		}
+	}
+	last := 0
+	for i := 0; i < len(inter); i++ {
+		r := inter[i]
+		n := 0
+		if r == '〱' {
+			n = 1
+		} else if r == '〳' && i+1 < len(inter) && inter[i+1] == '〵' {
+			n = 2
+		}
+		if n == 0 || i-last < 2 {
+			emit(r, offs[i], offs[i+1])
+			continue
+		}
+		emit(inter[i-2], offs[i], offs[i+n])
+		emit(inter[i-1], offs[i], offs[i+n])
+		i += n - 1
+		last = i + 1
+	}
+	return sb.String(), src
+}
+
+// mapSpans maps spans ([offset, length] in runes) in the expanded text to
+// those in the original text by src of ExpandOdoriji
+func mapSpans(text, expanded string, src [][2]int, spans [][2]int) [][2]int {
+	bytes := runeOffsets(expanded)
+	runes := byteRunes(text)
+
+	mapped := make([][2]int, len(spans))
+	for i, sp := range spans {
+		if sp[0] < 0 || sp[1] == 0 {
+			mapped[i] = [2]int{-1, 0}
+			continue
+		}
+		b := src[bytes[sp[0]]][0]
+		e := src[bytes[sp[0]+sp[1]]-1][1]
+		mapped[i] = [2]int{runes[b], runes[e] - runes[b]}
+	}
+	return mapped
+}
+
+// expandOdorijiString returns s with the iteration marks expanded
+func expandOdorijiString(s string) string {
+	x, _ := ExpandOdoriji(s)
+	return x
+}
+
+// odorijiCharFilters returns the char filters which expand the iteration
+// marks as ExpandOdoriji; names to the char filters
+func odorijiCharFilters() map[string]types.CharFilter {
+	kana := types.NewMappingCharFilter()
+	for _, rng := range [][2]rune{{'ぁ', 'ゖ'}, {'ァ', 'ヺ'}} {
+		for r := rng[0]; r <= rng[1]; r++ {
+			for _, m := range "ゝゞヽヾ" {
+				if x := expandKanaMark(r, m); x != 0 {
+					kana.Mappings = append(kana.Mappings, fmt.Sprintf("%c%c=>%c%c", r, m, r, x))
+				}
+			}
+		}
+	}
+
+	kanji := types.NewPatternReplaceCharFilter()
+	kanji.Pattern = `([\p{IsHan}&&[^々]])々`
+	kanji.Replacement = Str2Pt("$1$1")
+
+	double := types.NewPatternReplaceCharFilter()
+	double.Pattern = `(..)(?:〱|〳〵)`
+	double.Replacement = Str2Pt("$1$1")
+
+	return map[string]types.CharFilter{
+		"my_odoriji_kana":   kana,
+		"my_odoriji_kanji":  kanji,
+		"my_odoriji_double": double,
+	}
+}
+
+// names of odorijiCharFilters in order
+var odorijiCharFilterNames = []string{
+	"my_odoriji_kana",
+	"my_odoriji_kanji",
+	"my_odoriji_double",
+}
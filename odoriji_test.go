@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestExpandOdoriji(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"つゝ", "つつ"},
+		{"すゞし", "すずし"},
+		{"ぶゝ", "ぶふ"},
+		{"いすゞ", "いすず"},
+		{"ハヽ", "ハハ"},
+		{"人々", "人人"},
+		{"人々々", "人人々"},
+		{"ゝ々", "ゝ々"},
+		{"かゝゝ", "かかゝ"},
+		{"いよ〱", "いよいよ"},
+		{"いよ〳〵", "いよいよ"},
+		{"つゝ〱", "つつつつ"},
+		{"いよ〱〱", "いよいよ〱"},
+		{"給ふ", "給ふ"},
+	}
+	for _, tt := range tests {
+		got, src := ExpandOdoriji(tt.s)
+		if got != tt.want {
+			t.Errorf("ExpandOdoriji(%s) => %s, want %s", tt.s, got, tt.want)
+		}
+		if len(src) != len(got) {
+			t.Errorf("ExpandOdoriji(%s): %d offsets, want %d", tt.s, len(src), len(got))
+		}
+	}
+
+	// offsets of "いよ" of the expanded in the original
+	_, src := ExpandOdoriji("又いよ〳〵也")
+	if got := [2]int{src[len("又いよ")][0], src[len("又いよいよ")-1][1]}; got != [2]int{len("又いよ"), len("又いよ〳〵")} {
+		t.Errorf("ExpandOdoriji: offsets %v", got)
+	}
+
+	cfs := odorijiCharFilters()
+	for _, name := range odorijiCharFilterNames {
+		if _, ok := cfs[name]; !ok {
+			t.Errorf("odorijiCharFilters: %s missing", name)
+		}
+	}
+	kana := cfs["my_odoriji_kana"].(*types.MappingCharFilter)
+	for _, m := range []string{"つゝ=>つつ", "すゞ=>すず", "ハヽ=>ハハ"} {
+		if !slices.Contains(kana.Mappings, m) {
+			t.Errorf("my_odoriji_kana: %s missing", m)
+		}
+	}
+}
+
+func TestQueryNodeOdoriji(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("?つつ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags := n.WildcardFragments("思ひつゝも", 50)
+	if len(frags) != 1 || frags[0] != `思<em class="hltw1">ひつゝ</em>も` {
+		t.Errorf("WildcardFragments => %v", frags)
+	}
+
+	n, err = ParseQuery("つゝ NEAR/1 いよ〱")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.MatchesFragment("つつみいよいよ") {
+		t.Errorf("MatchesFragment => false, want true")
+	}
+}
+
+func TestMapSpans(t *testing.T) {
+	t.Parallel()
+
+	text := "いよ〱つゝ"
+	expanded, src := ExpandOdoriji(text)
+	got := mapSpans(text, expanded, src, [][2]int{{0, 4}, {2, 2}, {4, 2}, {5, 1}, {-1, 0}})
+	want := [][2]int{{0, 3}, {2, 1}, {3, 2}, {4, 1}, {-1, 0}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mapSpans mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type PageXMLDocument struct {
+	XMLName xml.Name      `xml:"PcGts"`
+	Pages   []PageXMLPage `xml:"Page"`
+}
+
+type PageXMLPage struct {
+	ImageFilename string               `xml:"imageFilename,attr"`
+	ImageWidth    int                  `xml:"imageWidth,attr"`
+	ImageHeight   int                  `xml:"imageHeight,attr"`
+	ReadingOrder  []PageXMLRegionRef   `xml:"ReadingOrder>OrderedGroup>RegionRefIndexed"`
+	TextRegions   []PageXMLTextRegion  `xml:"TextRegion"`
+	TableRegions  []PageXMLTableRegion `xml:"TableRegion"`
+}
+
+type PageXMLRegionRef struct {
+	Index     int    `xml:"index,attr"`
+	RegionRef string `xml:"regionRef,attr"`
+}
+
+type PageXMLTableRegion struct {
+	ID          string              `xml:"id,attr"`
+	TextRegions []PageXMLTextRegion `xml:"TextRegion"`
+}
+
+type PageXMLTextRegion struct {
+	ID          string              `xml:"id,attr"`
+	TextLines   []PageXMLTextLine   `xml:"TextLine"`
+	TextRegions []PageXMLTextRegion `xml:"TextRegion"`
+}
+
+type PageXMLTextLine struct {
+	ID        string             `xml:"id,attr"`
+	Coords    PageXMLCoords      `xml:"Coords"`
+	Words     []PageXMLWord      `xml:"Word"`
+	TextEquiv []PageXMLTextEquiv `xml:"TextEquiv"`
+}
+
+type PageXMLWord struct {
+	Coords    PageXMLCoords      `xml:"Coords"`
+	TextEquiv []PageXMLTextEquiv `xml:"TextEquiv"`
+}
+
+type PageXMLCoords struct {
+	Points string `xml:"points,attr"`
+}
+
+type PageXMLTextEquiv struct {
+	Index   *int     `xml:"index,attr"`
+	Conf    *float64 `xml:"conf,attr"`
+	Unicode string   `xml:"Unicode"`
+}
+
+func init() {
+	RegisterOCRFormat(&OCRFormat{
+		Name:     "pagexml",
+		Descr:    "PAGE XML (PRImA, e.g. Transkribus)",
+		Patterns: []string{"*.xml", "page/*.xml", "**/page/*.xml"},
+		Excludes: []string{"mets.xml", "METS.xml"},
+		Parser:   OCRParserFunc(parsePageXMLFile),
+		Sniff:    sniffPageXML,
+	})
+}
+
+func sniffPageXML(raw []byte) bool {
+	return sniffXMLRoot(raw) == "PcGts"
+}
+
+func parsePageXMLFile(b *BookTextBuilder, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var doc PageXMLDocument
+	if err := xml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	for _, page := range doc.Pages {
+		b.AddPage()
+		if page.ImageWidth > 0 {
+			b.SetPageImage(page.ImageFilename, page.ImageWidth, page.ImageHeight)
+		}
+		for _, region := range page.SortedRegions() {
+			for _, line := range region.Lines() {
+				text, conf, segs := line.TextAndConfidence()
+				if text == "" {
+					continue
+				}
+				bb := line.Coords.BB()
+				bb.Confidence = conf
+				b.AddLine(text, bb, segs...)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SortedRegions returns top-level text regions of the page in the
+// ReadingOrder if any, in document order otherwise
+func (p *PageXMLPage) SortedRegions() []PageXMLTextRegion {
+	regions := append([]PageXMLTextRegion{}, p.TextRegions...)
+	for _, tr := range p.TableRegions {
+		regions = append(regions, tr.TextRegions...)
+	}
+	if len(p.ReadingOrder) == 0 {
+		return regions
+	}
+
+	order := map[string]int{}
+	for _, ref := range p.ReadingOrder {
+		order[ref.RegionRef] = ref.Index
+	}
+	sort.SliceStable(regions, func(i, j int) bool {
+		oi, ok := order[regions[i].ID]
+		if !ok {
+			return false
+		}
+		oj, ok := order[regions[j].ID]
+		if !ok {
+			return true
+		}
+		return oi < oj
+	})
+	return regions
+}
+
+// Lines returns lines of the region including its nested regions
+func (r *PageXMLTextRegion) Lines() []PageXMLTextLine {
+	lines := append([]PageXMLTextLine{}, r.TextLines...)
+	for _, sub := range r.TextRegions {
+		lines = append(lines, sub.Lines()...)
+	}
+	return lines
+}
+
+// TextAndConfidence returns the line TextEquiv, or the joined Word
+// TextEquivs if the line has none, and the Word boxes as segs if they
+// are consistent with the line text
+func (l *PageXMLTextLine) TextAndConfidence() (string, float64, []*Seg) {
+	words := []string{}
+	segs := []*Seg{}
+	// indices in words of segs
+	segWords := []int{}
+	var (
+		sum float64
+		cnt int
+	)
+	for _, w := range l.Words {
+		te := firstTextEquiv(w.TextEquiv)
+		if te == nil || te.Unicode == "" {
+			continue
+		}
+		words = append(words, te.Unicode)
+		// words without coords have no segs
+		if bb := w.Coords.BB(); bb.Width > 0 && bb.Height > 0 {
+			segs = append(segs, &Seg{
+				Len: utf8.RuneCountInString(te.Unicode),
+				BB:  *bb,
+			})
+			segs[len(segs)-1].Confidence = te.Confidence()
+			segWords = append(segWords, len(words)-1)
+		}
+		if te.Conf != nil {
+			sum += *te.Conf
+			cnt++
+		}
+	}
+	joined, offsets := JoinWordsWithOffsets(words)
+	for i, seg := range segs {
+		seg.Pos = offsets[segWords[i]]
+	}
+
+	if te := firstTextEquiv(l.TextEquiv); te != nil {
+		if te.Unicode != joined {
+			segs = nil
+		}
+		return te.Unicode, te.Confidence(), segs
+	}
+
+	if cnt == 0 {
+		return joined, 0, segs
+	}
+	return joined, sum / float64(cnt), segs
+}
+
+func (te *PageXMLTextEquiv) Confidence() float64 {
+	if te.Conf == nil {
+		return 0
+	}
+	return *te.Conf
+}
+
+// firstTextEquiv returns the TextEquiv with the lowest index
+func firstTextEquiv(tes []PageXMLTextEquiv) *PageXMLTextEquiv {
+	var first *PageXMLTextEquiv
+	for i := range tes {
+		te := &tes[i]
+		if first == nil ||
+			(te.Index != nil && (first.Index == nil || *te.Index < *first.Index)) {
+			first = te
+		}
+	}
+	return first
+}
+
+// BB returns the bounding box of the polygon "x1,y1 x2,y2 ..."
+func (c *PageXMLCoords) BB() *BB {
+	var minX, minY, maxX, maxY int
+	// whether a valid point is seen
+	seen := false
+	for _, p := range strings.Fields(c.Points) {
+		xy := strings.Split(p, ",")
+		if len(xy) != 2 {
+			continue
+		}
+		x, err1 := strconv.Atoi(xy[0])
+		y, err2 := strconv.Atoi(xy[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if !seen || x < minX {
+			minX = x
+		}
+		if !seen || y < minY {
+			minY = y
+		}
+		if !seen || x > maxX {
+			maxX = x
+		}
+		if !seen || y > maxY {
+			maxY = y
+		}
+		seen = true
+	}
+	return &BB{
+		X:      minX,
+		Y:      minY,
+		Width:  maxX - minX,
+		Height: maxY - minY,
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestPageXMLCoordsBB(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		points string
+		want   BB
+	}{
+		{"10,20 30,20 30,50 10,50", BB{X: 10, Y: 20, Width: 20, Height: 30}},
+		// malformed points skipped, even the first
+		{"x,1 10,20 30 30,50", BB{X: 10, Y: 20, Width: 20, Height: 30}},
+		{"", BB{}},
+	}
+	for _, tt := range tests {
+		c := PageXMLCoords{Points: tt.points}
+		if diff := cmp.Diff(tt.want, *c.BB()); diff != "" {
+			t.Errorf("BB(%q) mismatch (-want +got):\n%s", tt.points, diff)
+		}
+	}
+}
+
+func TestPageXMLTextAndConfidence(t *testing.T) {
+	t.Parallel()
+
+	word := func(s, points string) PageXMLWord {
+		return PageXMLWord{
+			Coords:    PageXMLCoords{Points: points},
+			TextEquiv: []PageXMLTextEquiv{{Unicode: s}},
+		}
+	}
+	l := &PageXMLTextLine{Words: []PageXMLWord{
+		word("昔", "10,10 30,30"),
+		// no coords
+		word("男", ""),
+		word("あり", "10,50 30,90"),
+	}}
+
+	text, _, segs := l.TextAndConfidence()
+	if text != "昔男あり" {
+		t.Errorf("TextAndConfidence => %s, want 昔男あり", text)
+	}
+	want := []*Seg{
+		{Pos: 0, Len: 1, BB: BB{X: 10, Y: 10, Width: 20, Height: 20}},
+		{Pos: 2, Len: 2, BB: BB{X: 10, Y: 50, Width: 20, Height: 40}},
+	}
+	if diff := cmp.Diff(want, segs); diff != "" {
+		t.Errorf("TextAndConfidence segs mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// The query language of /api/search:
+//
+//	expr    := or
+//	or      := and ("OR" and)*
+//	and     := unary (["AND" | ","] unary)*
+//	unary   := "NOT" unary | near
+//	near    := primary [("NEAR" | "NEAR/" n) primary]
+//	primary := "(" expr ")" | [field ":"] (word | "quoted phrase")
+//
+// Words next to each other are ANDed. Text terms match phrases in text;
+// fields are elevel, tag, bid, license, attribution and the metadata
+// fields, e.g. `(俳諧 OR 連歌) NOT 和歌 elevel:OCR year:1600..1699`.
+// "A NEAR/n B" matches A and B in any order with at most n characters
+// between them, e.g. `俳諧 NEAR/20 和歌`. Text terms may have wildcards,
+// e.g. `御?上`; see query_wildcard.go. "lemma:" and "pos:" match MeCab
+// tokens; see query_morph.go. Text terms may be searched by reading; see
+// query_reading.go.
+
+/* QueryOp */
+type QueryOp int
+
+const (
+	QueryTerm QueryOp = iota
+	QueryAnd
+	QueryOr
+	QueryNot
+	QueryNear
+)
+
+// n of "NEAR" without "/n"
+const defaultNearDistance = 10
+
+/* QueryNode */
+type QueryNode struct {
+	Op QueryOp
+	// field of QueryTerm; "" for text
+	Field string
+	Value string
+	// part of speech of a lemma term, e.g. `pos:動詞 lemma:給ふ`
+	PartOfSpeech string
+	// max characters between the two terms of QueryNear
+	Distance int
+	// whether □ of the text matches any character of a text term
+	Unknown bool
+	// whether a text term matches the variants of the characters
+	Fuzzy bool
+	// whether text terms are searched with the kana folded
+	Kana bool
+	// whether text terms, converted to kana, are searched by reading
+	ByReading bool
+	// position (runes, 1-origin) in the query
+	Pos      int
+	Children []*QueryNode
+}
+
+/* QueryError */
+// QueryError is a parse error at Pos (runes, 1-origin) of the query
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+/* lexer */
+
+type queryTokenType int
+
+const (
+	tokEOF queryTokenType = iota
+	tokWord
+	tokPhrase
+	tokLParen
+	tokRParen
+	tokComma
+	tokAnd
+	tokOr
+	tokNot
+	tokNear
+)
+
+type queryToken struct {
+	typ queryTokenType
+	val string
+	pos int
+}
+
+func isQuerySpace(r rune) bool {
+	return unicode.IsSpace(r) // incl. U+3000
+}
+
+func lexQuery(q string) ([]queryToken, error) {
+	rs := []rune(q)
+	toks := []queryToken{}
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		pos := i + 1
+		switch {
+		case isQuerySpace(r):
+			i++
+		case r == '(' || r == '（':
+			toks = append(toks, queryToken{tokLParen, string(r), pos})
+			i++
+		case r == ')' || r == '）':
+			toks = append(toks, queryToken{tokRParen, string(r), pos})
+			i++
+		case r == ',' || r == '，':
+			toks = append(toks, queryToken{tokComma, string(r), pos})
+			i++
+		case r == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, &QueryError{pos, "unterminated quote"}
+			}
+			toks = append(toks, queryToken{tokPhrase, sb.String(), pos})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(rs); j++ {
+				c := rs[j]
+				if isQuerySpace(c) || strings.ContainsRune("()（）,，\"", c) {
+					break
+				}
+			}
+			w := string(rs[i:j])
+			typ := tokWord
+			switch w {
+			case "AND":
+				typ = tokAnd
+			case "OR":
+				typ = tokOr
+			case "NOT":
+				typ = tokNot
+			default:
+				if w == "NEAR" || strings.HasPrefix(w, "NEAR/") {
+					typ = tokNear
+				}
+			}
+			toks = append(toks, queryToken{typ, w, pos})
+			i = j
+		}
+	}
+	return append(toks, queryToken{tokEOF, "", len(rs) + 1}), nil
+}
+
+/* parser */
+
+type queryParser struct {
+	toks []queryToken
+	i    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.toks[p.i]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.toks[p.i]
+	if t.typ != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// ParseQuery parses q into a *QueryNode
+func ParseQuery(q string) (*QueryNode, error) {
+	toks, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	if p.peek().typ == tokEOF {
+		return nil, &QueryError{1, "empty query"}
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, &QueryError{t.pos, fmt.Sprintf("unexpected %q", t.val)}
+	}
+	return n, nil
+}
+
+func (p *queryParser) parseOr() (*QueryNode, error) {
+	pos := p.peek().pos
+	n, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []*QueryNode{n}
+	for p.peek().typ == tokOr {
+		p.next()
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &QueryNode{Op: QueryOr, Pos: pos, Children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (*QueryNode, error) {
+	pos := p.peek().pos
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []*QueryNode{n}
+	for {
+		switch p.peek().typ {
+		case tokAnd, tokComma:
+			p.next()
+		case tokWord, tokPhrase, tokLParen, tokNot:
+			// implicit AND
+		default:
+			if len(children) == 1 {
+				return children[0], nil
+			}
+			return &QueryNode{Op: QueryAnd, Pos: pos, Children: children}, nil
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+}
+
+func (p *queryParser) parseUnary() (*QueryNode, error) {
+	if t := p.peek(); t.typ == tokNot {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if w := n.wildcardTerm(); w != nil {
+			return nil, &QueryError{w.Pos, "wildcard not allowed for NOT"}
+		}
+		return &QueryNode{Op: QueryNot, Pos: t.pos, Children: []*QueryNode{n}}, nil
+	}
+	return p.parseNear()
+}
+
+func (p *queryParser) parseNear() (*QueryNode, error) {
+	a, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.typ != tokNear {
+		return a, nil
+	}
+	p.next()
+
+	dist := defaultNearDistance
+	if s, ok := strings.CutPrefix(t.val, "NEAR/"); ok {
+		if dist, err = strconv.Atoi(s); err != nil || dist < 0 {
+			return nil, &QueryError{t.pos + 5, fmt.Sprintf("distance expected: %s", s)}
+		}
+	}
+	b, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range []*QueryNode{a, b} {
+		if n.Op != QueryTerm || n.Field != "" {
+			return nil, &QueryError{n.Pos, "text term expected for NEAR"}
+		}
+		if hasWildcard(n.Value) {
+			return nil, &QueryError{n.Pos, "wildcard not allowed for NEAR"}
+		}
+	}
+	if c := p.peek(); c.typ == tokNear {
+		return nil, &QueryError{c.pos, "NEAR cannot be chained"}
+	}
+
+	return &QueryNode{
+		Op:       QueryNear,
+		Distance: dist,
+		Pos:      a.Pos,
+		Children: []*QueryNode{a, b},
+	}, nil
+}
+
+func (p *queryParser) parsePrimary() (*QueryNode, error) {
+	t := p.next()
+	switch t.typ {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.typ != tokRParen {
+			return nil, &QueryError{c.pos, fmt.Sprintf("\")\" expected for \"(\" at %d", t.pos)}
+		}
+		return n, nil
+	case tokPhrase:
+		if t.val == "" {
+			return nil, &QueryError{t.pos, "empty phrase"}
+		}
+		return newTextTerm(t)
+	case tokWord:
+		return p.parseTerm(t)
+	case tokEOF:
+		return nil, &QueryError{t.pos, "term expected at end of query"}
+	case tokNear:
+		return nil, &QueryError{t.pos, "term expected before NEAR"}
+	default:
+		return nil, &QueryError{t.pos, fmt.Sprintf("term expected, got %q", t.val)}
+	}
+}
+
+// parseTerm parses a word, which may be "field:value" or "field:" with
+// the quoted value following, and a morph term following it if any
+func (p *queryParser) parseTerm(t queryToken) (*QueryNode, error) {
+	n, err := p.parseField(t)
+	if err != nil {
+		return nil, err
+	}
+	if n.IsMorph() {
+		return p.mergeMorph(n)
+	}
+	return n, nil
+}
+
+func (p *queryParser) parseField(t queryToken) (*QueryNode, error) {
+	i := strings.IndexRune(t.val, ':')
+	if i <= 0 || !isQueryFieldName(t.val[:i]) {
+		return newTextTerm(t)
+	}
+
+	field := t.val[:i]
+	if !isQueryField(field) {
+		return nil, &QueryError{t.pos, fmt.Sprintf("unknown field: %s", field)}
+	}
+	value := t.val[i+1:]
+	if value == "" {
+		if v := p.peek(); v.typ == tokPhrase && v.pos == t.pos+i+1 {
+			p.next()
+			value = v.val
+		}
+	}
+	if value == "" {
+		return nil, &QueryError{t.pos, fmt.Sprintf("value expected for %s:", field)}
+	}
+
+	n := &QueryNode{Op: QueryTerm, Field: field, Value: value, Pos: t.pos}
+	if err := n.checkFieldValue(); err != nil {
+		return nil, &QueryError{t.pos + i + 1, err.Error()}
+	}
+	return n, nil
+}
+
+func newTextTerm(t queryToken) (*QueryNode, error) {
+	n := &QueryNode{Op: QueryTerm, Value: t.val, Pos: t.pos}
+	if hasWildcard(n.Value) {
+		if err := n.checkWildcard(); err != nil {
+			return nil, &QueryError{t.pos, err.Error()}
+		}
+	}
+	return n, nil
+}
+
+// wildcardTerm returns the first text term with wildcards in n
+func (n *QueryNode) wildcardTerm() *QueryNode {
+	if n.Op == QueryTerm {
+		if n.Field == "" && hasWildcard(n.Value) {
+			return n
+		}
+		return nil
+	}
+	for _, c := range n.Children {
+		if w := c.wildcardTerm(); w != nil {
+			return w
+		}
+	}
+	return nil
+}
+
+func isQueryFieldName(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// ES fields of the query fields except metadata fields
+var queryFields = map[string]string{
+	"elevel":      "elevel",
+	"tag":         "tags",
+	"bid":         "bid",
+	"license":     "license",
+	"attribution": "attribution",
+}
+
+func isQueryField(name string) bool {
+	if isMorphField(name) {
+		return true
+	}
+	if _, ok := queryFields[name]; ok {
+		return true
+	}
+	_, ok := metadataField(name)
+	return ok
+}
+
+func metadataField(name string) (MetadataField, bool) {
+	for _, f := range MetadataFields() {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return MetadataField{}, false
+}
+
+func (n *QueryNode) checkFieldValue() error {
+	if n.Field == "pos" {
+		return checkPOS(n.Value)
+	}
+	if n.Field == "elevel" {
+		if _, err := ELevelString(n.Value); err != nil {
+			return err
+		}
+		return nil
+	}
+	if f, ok := metadataField(n.Field); ok && f.Type == BiblioYear {
+		if _, _, err := parseYearRange(n.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseYearRange parses "1626", "1600..1699", "1600.." or "..1699"
+func parseYearRange(s string) (from, to *int, err error) {
+	parse := func(v string) (*int, error) {
+		if v == "" {
+			return nil, nil
+		}
+		y, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("year expected: %s", v)
+		}
+		return &y, nil
+	}
+
+	a, b, isRange := strings.Cut(s, "..")
+	if from, err = parse(a); err != nil {
+		return nil, nil, err
+	}
+	if !isRange {
+		if from == nil {
+			return nil, nil, fmt.Errorf("year expected")
+		}
+		return from, from, nil
+	}
+	if to, err = parse(b); err != nil {
+		return nil, nil, err
+	}
+	if from == nil && to == nil {
+		return nil, nil, fmt.Errorf("year expected: %s", s)
+	}
+	return from, to, nil
+}
+
+// HasText reports whether n has a positive text or morph term to
+// highlight
+func (n *QueryNode) HasText() bool {
+	switch n.Op {
+	case QueryTerm:
+		return n.Field == "" || n.IsMorph()
+	case QueryNot:
+		return false
+	}
+	for _, c := range n.Children {
+		if c.HasText() {
+			return true
+		}
+	}
+	return false
+}
+
+/* compiler */
+
+// SetKana lets the text terms be searched with the kana folded
+func (n *QueryNode) SetKana() {
+	n.Kana = true
+	for _, c := range n.Children {
+		c.SetKana()
+	}
+}
+
+// TextField returns the ES field of the text terms
+func (n *QueryNode) TextField() string {
+	if n.ByReading {
+		return readingField
+	}
+	if n.Kana {
+		return kanaField
+	}
+	return "text"
+}
+
+// value returns Value normalized as the text field does
+func (n *QueryNode) value() string {
+	return n.normalizeText(n.Value)
+}
+
+// normalizeText normalizes s as the text field does; see NormalizeText
+func (n *QueryNode) normalizeText(s string) string {
+	s, _ = NormalizeText(s, n.Kana)
+	return s
+}
+
+// ESQuery compiles n to an ES query
+func (n *QueryNode) ESQuery() types.Query {
+	switch n.Op {
+	case QueryAnd:
+		return types.Query{Bool: &types.BoolQuery{Must: esQueries(n.Children)}}
+	case QueryOr:
+		return types.Query{Bool: &types.BoolQuery{
+			Should:             esQueries(n.Children),
+			MinimumShouldMatch: 1,
+		}}
+	case QueryNot:
+		return types.Query{Bool: &types.BoolQuery{MustNot: esQueries(n.Children)}}
+	case QueryNear:
+		// bigrams of a term are at consecutive positions; the gap of
+		// positions between two terms is the characters between them + 1
+		rules := make([]types.Intervals, len(n.Children))
+		for i, c := range n.Children {
+			rules[i] = types.Intervals{Match: &types.IntervalsMatch{
+				Query:   c.Value,
+				MaxGaps: Int2Pt(0),
+				Ordered: Bool2Pt(true),
+			}}
+		}
+		return types.Query{
+			Intervals: map[string]types.IntervalsQuery{
+				n.TextField(): {
+					AllOf: &types.IntervalsAllOf{
+						Intervals: rules,
+						MaxGaps:   Int2Pt(n.Distance + 1),
+						Ordered:   Bool2Pt(false),
+					},
+				},
+			},
+		}
+	}
+
+	if n.IsWildcard() {
+		return n.wildcardESQuery()
+	}
+	if n.IsMorph() {
+		return n.morphESQuery()
+	}
+	if n.Field == "" {
+		return types.Query{
+			MatchPhrase: map[string]types.MatchPhraseQuery{
+				n.TextField(): {
+					Query: n.Value,
+				},
+			},
+		}
+	}
+
+	field, ok := queryFields[n.Field]
+	if !ok {
+		f, _ := metadataField(n.Field)
+		field = "biblio." + f.Name
+		if f.Type == BiblioYear {
+			from, to, _ := parseYearRange(n.Value)
+			nr := types.NumberRangeQuery{}
+			if from != nil {
+				v := types.Float64(*from)
+				nr.Gte = &v
+			}
+			if to != nil {
+				v := types.Float64(*to)
+				nr.Lte = &v
+			}
+			return types.Query{Range: map[string]types.RangeQuery{field: nr}}
+		}
+	}
+	return types.Query{
+		Term: map[string]types.TermQuery{
+			field: {
+				Value: n.Value,
+			},
+		},
+	}
+}
+
+func esQueries(ns []*QueryNode) []types.Query {
+	qs := make([]types.Query, len(ns))
+	for i, n := range ns {
+		qs[i] = n.ESQuery()
+	}
+	return qs
+}
+
+func (n *QueryNode) String() string {
+	switch n.Op {
+	case QueryAnd, QueryOr:
+		op := " AND "
+		if n.Op == QueryOr {
+			op = " OR "
+		}
+		ss := make([]string, len(n.Children))
+		for i, c := range n.Children {
+			ss[i] = c.String()
+		}
+		return "(" + strings.Join(ss, op) + ")"
+	case QueryNot:
+		return "NOT " + n.Children[0].String()
+	case QueryNear:
+		return fmt.Sprintf("(%s NEAR/%d %s)",
+			n.Children[0].String(), n.Distance, n.Children[1].String())
+	}
+	v := strconv.Quote(n.Value)
+	if n.PartOfSpeech != "" {
+		return "pos:" + strconv.Quote(n.PartOfSpeech) + " lemma:" + v
+	}
+	if n.Field != "" {
+		return n.Field + ":" + v
+	}
+	return v
+}
+
+/* highlighting */
+
+// HasNear reports whether n has a positive NEAR
+func (n *QueryNode) HasNear() bool {
+	switch n.Op {
+	case QueryNear:
+		return true
+	case QueryTerm, QueryNot:
+		return false
+	}
+	for _, c := range n.Children {
+		if c.HasNear() {
+			return true
+		}
+	}
+	return false
+}
+
+// units returns the positive text terms and NEARs, which a fragment
+// should match
+func (n *QueryNode) units() []*QueryNode {
+	switch n.Op {
+	case QueryTerm:
+		if n.Field == "" {
+			return []*QueryNode{n}
+		}
+		return nil
+	case QueryNear:
+		return []*QueryNode{n}
+	case QueryNot:
+		return nil
+	}
+	units := []*QueryNode{}
+	for _, c := range n.Children {
+		units = append(units, c.units()...)
+	}
+	return units
+}
+
+// HighlightQuery returns the query to highlight the positive text terms
+// including those of NEARs, which are not highlighted by the fvh; the
+// wildcard terms are left to WildcardFragments
+func (n *QueryNode) HighlightQuery() *types.Query {
+	qs := []types.Query{}
+	for _, u := range n.units() {
+		if u.IsWildcard() {
+			continue
+		}
+		terms := []*QueryNode{u}
+		if u.Op == QueryNear {
+			terms = u.Children
+		}
+		for _, t := range terms {
+			qs = append(qs, t.ESQuery())
+		}
+	}
+	return &types.Query{Bool: &types.BoolQuery{Should: qs}}
+}
+
+// FragmentSize returns the fragment size to have both the terms of
+// NEARs in one fragment, at least size
+func (n *QueryNode) FragmentSize(size int) int {
+	for _, u := range n.units() {
+		if u.Op != QueryNear {
+			continue
+		}
+		a, b := u.Children[0].Value, u.Children[1].Value
+		size = max(size, u.Distance+utf8.RuneCountInString(a)+utf8.RuneCountInString(b))
+	}
+	return size
+}
+
+// MatchesFragment reports whether the fragment t (without tags) has a
+// text term, or the terms of a NEAR close enough
+func (n *QueryNode) MatchesFragment(t string) bool {
+	t = n.normalizeText(t)
+	for _, u := range n.units() {
+		if u.IsWildcard() {
+			if u.Regexp().MatchString(t) {
+				return true
+			}
+			continue
+		}
+		if u.Op != QueryNear {
+			if strings.Contains(t, u.value()) {
+				return true
+			}
+			continue
+		}
+		a, b := u.Children[0].value(), u.Children[1].value()
+		la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+		for _, pa := range runeIndices(t, a) {
+			for _, pb := range runeIndices(t, b) {
+				gap := pb - (pa + la)
+				if pb < pa {
+					gap = pa - (pb + lb)
+				}
+				if gap <= u.Distance {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// runeIndices returns the offsets (runes) of all the occurrences of sub
+func runeIndices(s, sub string) []int {
+	idxs := []int{}
+	if sub == "" {
+		return idxs
+	}
+	for off := 0; ; {
+		i := strings.Index(s[off:], sub)
+		if i == -1 {
+			return idxs
+		}
+		idxs = append(idxs, utf8.RuneCountInString(s[:off+i]))
+		_, size := utf8.DecodeRuneInString(s[off+i:])
+		off += i + size
+	}
+}
+
+// IsVariant reports whether word is not a text term of n as is but
+// normalized as the text field does, e.g. 國學 for 国学
+func (n *QueryNode) IsVariant(word string) bool {
+	for _, u := range n.units() {
+		terms := []*QueryNode{u}
+		if u.Op == QueryNear {
+			terms = u.Children
+		}
+		for _, t := range terms {
+			if !t.IsWildcard() && word != t.Value && t.normalizeText(word) == t.value() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// Lemma and part-of-speech terms match the MeCab tokens of mecabed (and
+// mecabedExpanded), "pos1:pos2:pos3:pos4:lemma", so that a lemma finds
+// every inflected form: `lemma:給ふ`, `pos:助動詞` or both next to each other,
+// `pos:助動詞 lemma:けり`, for the tokens of both. The levels of a part of
+// speech are separated by "-", e.g. `pos:動詞-一般`. The matches are
+// highlighted by the spans of the tokens; see MorphFragments.
+
+// max levels of a part of speech
+const morphPOSLevels = 4
+
+// ES fields of the MeCab tokens
+var morphFields = []string{"mecabed", "mecabedExpanded"}
+
+func isMorphField(name string) bool {
+	return name == "lemma" || name == "pos"
+}
+
+// IsMorph reports whether n is a lemma or part-of-speech term
+func (n *QueryNode) IsMorph() bool {
+	return n.Op == QueryTerm && isMorphField(n.Field)
+}
+
+// mergeMorph merges the morph term n with "lemma:" or "pos:" following
+func (p *queryParser) mergeMorph(n *QueryNode) (*QueryNode, error) {
+	other := "lemma:"
+	if n.Field == "lemma" {
+		other = "pos:"
+	}
+	if t := p.peek(); t.typ != tokWord || !strings.HasPrefix(t.val, other) {
+		return n, nil
+	}
+	m, err := p.parseField(p.next())
+	if err != nil {
+		return nil, err
+	}
+	lemma, pos := n, m
+	if n.Field == "pos" {
+		lemma, pos = m, n
+	}
+	lemma.PartOfSpeech = pos.Value
+	lemma.Pos = min(n.Pos, m.Pos)
+	return lemma, nil
+}
+
+// posLevels returns the levels of the part of speech of n
+func (n *QueryNode) posLevels() []string {
+	pos := n.PartOfSpeech
+	if n.Field == "pos" {
+		pos = n.Value
+	}
+	if pos == "" {
+		return nil
+	}
+	return strings.Split(pos, "-")
+}
+
+func checkPOS(pos string) error {
+	levels := strings.Split(pos, "-")
+	if len(levels) > morphPOSLevels {
+		return fmt.Errorf("at most %d levels of part of speech: %s", morphPOSLevels, pos)
+	}
+	if slices.Contains(levels, "") {
+		return fmt.Errorf("empty level of part of speech: %s", pos)
+	}
+	return nil
+}
+
+// morphPattern returns the ES wildcard pattern of the tokens of n
+func (n *QueryNode) morphPattern() string {
+	levels := n.posLevels()
+	for i, l := range levels {
+		levels[i] = escapeWildcard(l)
+	}
+	if n.Field == "pos" {
+		return strings.Join(append(levels, "*"), ":")
+	}
+	lemma := escapeWildcard(n.Value)
+	if len(levels) == morphPOSLevels {
+		return strings.Join(append(levels, lemma), ":")
+	}
+	return strings.Join(append(levels, "*", lemma), ":")
+}
+
+func escapeWildcard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(s)
+}
+
+// morphESQuery returns the query of the tokens of n in either field
+func (n *QueryNode) morphESQuery() types.Query {
+	qs := []types.Query{}
+	for _, f := range morphFields {
+		qs = append(qs, types.Query{
+			Wildcard: map[string]types.WildcardQuery{
+				f: {Value: Str2Pt(n.morphPattern())},
+			},
+		})
+	}
+	return types.Query{Bool: &types.BoolQuery{Should: qs, MinimumShouldMatch: 1}}
+}
+
+// MatchesKey reports whether the MeCab token key matches the morph term n
+func (n *QueryNode) MatchesKey(key string) bool {
+	f := strings.Split(key, ":")
+	if len(f) != morphPOSLevels+1 {
+		return false
+	}
+	for i, l := range n.posLevels() {
+		if f[i] != l {
+			return false
+		}
+	}
+	return n.Field == "pos" || f[morphPOSLevels] == n.Value
+}
+
+// morphUnits returns the positive morph terms
+func (n *QueryNode) morphUnits() []*QueryNode {
+	switch n.Op {
+	case QueryTerm:
+		if n.IsMorph() {
+			return []*QueryNode{n}
+		}
+		return nil
+	case QueryNear, QueryNot:
+		return nil
+	}
+	units := []*QueryNode{}
+	for _, c := range n.Children {
+		units = append(units, c.morphUnits()...)
+	}
+	return units
+}
+
+// HasMorph reports whether n has a positive morph term
+func (n *QueryNode) HasMorph() bool {
+	return len(n.morphUnits()) > 0
+}
+
+/* Fragment */
+// Fragment is a highlighted fragment of the text and its offset (runes),
+// without the tags, in the text; -1 if unknown
+type Fragment struct {
+	Match string
+	Pos   int
+}
+
+// MorphFragments returns the fragments of the tokens of bt matching the
+// morph terms, highlighted as the fvh does with the class "hltmN" (N:
+// 1-origin index of the morph terms)
+func (n *QueryNode) MorphFragments(bt *BookText, size int) []Fragment {
+	offs := runeOffsets(bt.Text)
+
+	tokens := []struct {
+		keys  []string
+		spans [][2]int
+	}{
+		{bt.Mecabed, bt.MecabSpans},
+		{bt.MecabedExpanded, bt.MecabExpandedSpans},
+	}
+
+	frags := []Fragment{}
+	for k, u := range n.morphUnits() {
+		done := map[[2]int]bool{}
+		for _, tk := range tokens {
+			for i, key := range tk.keys {
+				if i >= len(tk.spans) || !u.MatchesKey(key) {
+					continue
+				}
+				sp := tk.spans[i]
+				if sp[0] < 0 || sp[0]+sp[1] >= len(offs) || done[sp] {
+					continue
+				}
+				done[sp] = true
+				frags = append(frags, highlightFragment(bt.Text,
+					[][2]int{{offs[sp[0]], offs[sp[0]+sp[1]]}}, sp[0], size, fmt.Sprintf("hltm%d", k+1)))
+			}
+		}
+	}
+	return frags
+}
+
+// highlightFragment returns the fragment of text around the ranges
+// [begin, end) (bytes, in order, not overlapping), of about size
+// characters, highlighted with class; pos is the offset (runes) of the
+// first range, -1 if unknown
+func highlightFragment(text string, ranges [][2]int, pos, size int, class string) Fragment {
+	mb, me := ranges[0][0], ranges[len(ranges)-1][1]
+	ctx := max(0, (size-utf8.RuneCountInString(text[mb:me]))/2)
+	b := mb
+	for i := 0; i < ctx && b > 0; i++ {
+		_, s := utf8.DecodeLastRuneInString(text[:b])
+		b -= s
+		if pos >= 0 {
+			pos--
+		}
+	}
+	e := me
+	for i := 0; i < ctx && e < len(text); i++ {
+		_, s := utf8.DecodeRuneInString(text[e:])
+		e += s
+	}
+
+	var sb strings.Builder
+	sb.WriteString(text[b:mb])
+	for i, r := range ranges {
+		if i > 0 {
+			sb.WriteString(text[ranges[i-1][1]:r[0]])
+		}
+		fmt.Fprintf(&sb, `<em class="%s">%s</em>`, class, text[r[0]:r[1]])
+	}
+	sb.WriteString(text[me:e])
+	return Fragment{Match: sb.String(), Pos: pos}
+}
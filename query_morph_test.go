@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestMorphParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"lemma:給ふ", `lemma:"給ふ"`},
+		{"pos:助動詞 lemma:けり", `pos:"助動詞" lemma:"けり"`},
+		{"lemma:けり pos:助動詞", `pos:"助動詞" lemma:"けり"`},
+		{"pos:動詞-一般 和歌", `(pos:"動詞-一般" AND "和歌")`},
+		{"lemma:けり lemma:給ふ", `(lemma:"けり" AND lemma:"給ふ")`},
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Errorf("ParseQuery(%s): %s", tt.q, err)
+			continue
+		}
+		if got := n.String(); got != tt.want {
+			t.Errorf("ParseQuery(%s) => %s, want %s", tt.q, got, tt.want)
+		}
+		if !n.HasText() {
+			t.Errorf("%s: HasText => false", tt.q)
+		}
+	}
+
+	for _, q := range []string{"pos:a-b-c-d-e", "pos:動詞--一般", "lemma:給ふ NEAR 和歌"} {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("ParseQuery(%s) => no error", q)
+		}
+	}
+}
+
+func TestMorphESQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"lemma:給ふ", "*:給ふ"},
+		{"pos:動詞-一般", "動詞:一般:*"},
+		{"pos:動詞 lemma:給ふ", "動詞:*:給ふ"},
+		{"pos:動詞-一般-*-* lemma:給ふ", `動詞:一般:\*:\*:給ふ`},
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Errorf("ParseQuery(%s): %s", tt.q, err)
+			continue
+		}
+		raw, err := json.Marshal(n.ESQuery())
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := json.Marshal(tt.want)
+		want := `{"bool":{"minimum_should_match":1,"should":[` +
+			`{"wildcard":{"mecabed":{"value":` + string(v) + `}}},` +
+			`{"wildcard":{"mecabedExpanded":{"value":` + string(v) + `}}}]}}`
+		if string(raw) != want {
+			t.Errorf("%s: ESQuery =>\n%s\nwant\n%s", tt.q, raw, want)
+		}
+	}
+}
+
+func TestMorphFragments(t *testing.T) {
+	t.Parallel()
+
+	bt := &BookText{
+		Text: "昔男ありけり。いとゞ思ひけり",
+		Mecabed: []string{
+			"名詞:普通名詞:一般:*:昔", "名詞:普通名詞:一般:*:男",
+			"動詞:非自立可能:*:*:有り", "助動詞:*:*:*:けり",
+			"補助記号:句点:*:*:。", "副詞:*:*:*:いとど",
+			"動詞:一般:*:*:思う", "助動詞:*:*:*:けり",
+		},
+		MecabSpans: [][2]int{
+			{0, 1}, {1, 1}, {2, 2}, {4, 2}, {6, 1}, {7, 3}, {10, 2}, {12, 2},
+		},
+	}
+
+	n, err := ParseQuery("pos:助動詞 lemma:けり")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := n.MorphFragments(bt, 6)
+	want := []Fragment{
+		{`あり<em class="hltm1">けり</em>。い`, 2},
+		{`思ひ<em class="hltm1">けり</em>`, 10},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("MorphFragments mismatch (-want +got):\n%s", diff)
+	}
+
+	n, err = ParseQuery("和歌 OR pos:副詞")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = n.MorphFragments(bt, 3)
+	want = []Fragment{{`<em class="hltm1">いとゞ</em>`, 7}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("MorphFragments mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTokenSpans(t *testing.T) {
+	t.Parallel()
+
+	got := tokenSpans("昔、男ありけり", []string{"", "昔", "男", "あり", "x", "けり", ""})
+	want := [][2]int{{-1, 0}, {0, 1}, {2, 1}, {3, 2}, {-1, 0}, {5, 2}, {-1, 0}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("tokenSpans mismatch (-want +got):\n%s", diff)
+	}
+}
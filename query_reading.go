@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Reading search: the text terms are converted to kana by MeCab and
+// searched in reading, the readings of the MeCab tokens joined, so that
+// 思ふ finds おもふ and vice versa. The matches are highlighted in the text
+// by the spans of the tokens which the matches of the readings cover.
+
+// field of the readings; see BookText.Reading
+const readingField = "reading"
+
+// SetReading converts the text terms of n to kana by toKana, to be
+// searched by reading; wildcards are not allowed
+func (n *QueryNode) SetReading(toKana func(string) (string, error)) error {
+	if w := n.wildcardTerm(); w != nil {
+		return &QueryError{w.Pos, "wildcard not allowed for reading"}
+	}
+	return n.setReading(toKana)
+}
+
+func (n *QueryNode) setReading(toKana func(string) (string, error)) error {
+	n.ByReading = true
+	if n.Op == QueryTerm && n.Field == "" {
+		v, err := toKana(n.Value)
+		if err != nil {
+			return fmt.Errorf("reading of %s: %s", n.Value, err)
+		}
+		if v == "" {
+			return &QueryError{n.Pos, fmt.Sprintf("no reading of %s", n.Value)}
+		}
+		n.Value = v
+	}
+	for _, c := range n.Children {
+		if err := c.setReading(toKana); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadingFragments returns the fragments of bt where the readings match
+// the text terms and NEARs of n, highlighted as the fvh does with the
+// class "hltrN" (N: 1-origin index of the terms and NEARs)
+func (n *QueryNode) ReadingFragments(bt *BookText, size int) []Fragment {
+	// src maps the offsets in the normalized readings to those in Reading
+	normalized, src := NormalizeText(bt.Reading, false)
+	nOffs := runeOffsets(normalized)
+	rRunes := byteRunes(bt.Reading)
+	tOffs := runeOffsets(bt.Text)
+
+	// surface returns the range (bytes) in Text of the tokens whose
+	// readings overlap the range (runes) of normalized, and its offset
+	// (runes)
+	surface := func(b, l int) ([2]int, int, bool) {
+		rb := rRunes[src[nOffs[b]][0]]
+		re := rRunes[src[nOffs[b+l]-1][1]]
+		sb, se := -1, -1
+		for i, sp := range bt.ReadingSpans {
+			if sp[0] >= re || sp[0]+sp[1] <= rb ||
+				i >= len(bt.MecabSpans) || bt.MecabSpans[i][0] < 0 {
+				continue
+			}
+			ts := bt.MecabSpans[i]
+			if sb == -1 || ts[0] < sb {
+				sb = ts[0]
+			}
+			se = max(se, ts[0]+ts[1])
+		}
+		if sb == -1 || se >= len(tOffs) {
+			return [2]int{}, 0, false
+		}
+		return [2]int{tOffs[sb], tOffs[se]}, sb, true
+	}
+
+	frags := []Fragment{}
+	for k, u := range n.units() {
+		class := fmt.Sprintf("hltr%d", k+1)
+		// matches in the same tokens
+		done := map[[4]int]bool{}
+		add := func(ranges [][2]int, pos int) {
+			key := [4]int{ranges[0][0], ranges[0][1], -1, -1}
+			if len(ranges) > 1 {
+				key[2], key[3] = ranges[1][0], ranges[1][1]
+			}
+			if !done[key] {
+				done[key] = true
+				frags = append(frags, highlightFragment(bt.Text, ranges, pos, size, class))
+			}
+		}
+
+		if u.Op != QueryNear {
+			v := u.value()
+			l := utf8.RuneCountInString(v)
+			for _, p := range runeIndices(normalized, v) {
+				if r, pos, ok := surface(p, l); ok {
+					add([][2]int{r}, pos)
+				}
+			}
+			continue
+		}
+
+		a, b := u.Children[0].value(), u.Children[1].value()
+		la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+		for _, pa := range runeIndices(normalized, a) {
+			for _, pb := range runeIndices(normalized, b) {
+				gap := pb - (pa + la)
+				if pb < pa {
+					gap = pa - (pb + lb)
+				}
+				if gap < 0 || gap > u.Distance {
+					continue
+				}
+				ra, posA, okA := surface(pa, la)
+				rb, posB, okB := surface(pb, lb)
+				if !okA || !okB {
+					continue
+				}
+				if rb[0] < ra[0] {
+					ra, rb = rb, ra
+					posA = posB
+				}
+				if ra[1] > rb[0] {
+					// in the same tokens
+					add([][2]int{{ra[0], max(ra[1], rb[1])}}, posA)
+				} else {
+					add([][2]int{ra, rb}, posA)
+				}
+			}
+		}
+	}
+	return frags
+}
+
+// runeOffsets returns the byte offsets of the runes of s and len(s)
+func runeOffsets(s string) []int {
+	offs := make([]int, 0, len(s)+1)
+	for i := range s {
+		offs = append(offs, i)
+	}
+	return append(offs, len(s))
+}
+
+// byteRunes returns the rune offsets of the bytes of s, at the rune
+// boundaries, and of len(s)
+func byteRunes(s string) []int {
+	runes := make([]int, len(s)+1)
+	r := 0
+	for i := range s {
+		runes[i] = r
+		r++
+	}
+	runes[len(s)] = r
+	return runes
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+// readings of the test instead of MeCab
+func testReading(s string) (string, error) {
+	r, ok := map[string]string{
+		"思ふ":  "おもふ",
+		"おもふ": "おもふ",
+		"けり":  "けり",
+		"昔":   "むかし",
+	}[s]
+	if !ok {
+		return "", errors.New("unknown word")
+	}
+	return r, nil
+}
+
+func TestSetReading(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("思ふ NOT 昔 lemma:けり")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetReading(testReading); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n.String(), `("おもふ" AND NOT "むかし" AND lemma:"けり")`; got != want {
+		t.Errorf("String => %s, want %s", got, want)
+	}
+	if got := n.TextField(); got != readingField {
+		t.Errorf("TextField => %s, want %s", got, readingField)
+	}
+	raw, err := json.Marshal(n.Children[0].ESQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"match_phrase":{"reading":{"query":"おもふ"}}}`; string(raw) != want {
+		t.Errorf("ESQuery => %s, want %s", raw, want)
+	}
+
+	n, err = ParseQuery("思?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var qe *QueryError
+	if err := n.SetReading(testReading); !errors.As(err, &qe) {
+		t.Errorf("SetReading(思?) => %v, want *QueryError", err)
+	}
+}
+
+func TestReadingFragments(t *testing.T) {
+	t.Parallel()
+
+	mt := &MecabTokens{
+		Readings: []string{"", "むかし", "おもふ", "けり", "。", "おもひ", "けり", ""},
+		Spans:    [][2]int{{-1, 0}, {0, 1}, {1, 2}, {3, 2}, {5, 1}, {6, 3}, {9, 2}, {-1, 0}},
+	}
+	bt := &BookText{Text: "昔思ふけり。おもひけり", MecabSpans: mt.Spans}
+	bt.Reading, bt.ReadingSpans = mt.Reading()
+	if want := "むかしおもふけり。おもひけり"; bt.Reading != want {
+		t.Fatalf("Reading => %s, want %s", bt.Reading, want)
+	}
+
+	tests := []struct {
+		q    string
+		size int
+		want []Fragment
+	}{
+		{"思ふ", 5, []Fragment{{`昔<em class="hltr1">思ふ</em>け`, 0}}},
+		// a part of a token highlights the token
+		{"思", 5, []Fragment{
+			{`昔<em class="hltr1">思ふ</em>け`, 0},
+			{`。<em class="hltr1">おもひ</em>け`, 5},
+		}},
+		{"昔 NEAR/3 けり", 5, []Fragment{
+			{`<em class="hltr1">昔</em>思ふ<em class="hltr1">けり</em>`, 0},
+		}},
+		// the same fragments of different matches
+		{"けり", 2, []Fragment{
+			{`<em class="hltr1">けり</em>`, 3},
+			{`<em class="hltr1">けり</em>`, 9},
+		}},
+	}
+	reading := func(s string) (string, error) {
+		if s == "思" {
+			return "おも", nil
+		}
+		return testReading(s)
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.SetReading(reading); err != nil {
+			t.Fatal(err)
+		}
+		got := n.ReadingFragments(bt, tt.size)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: ReadingFragments mismatch (-want +got):\n%s", tt.q, diff)
+		}
+	}
+}
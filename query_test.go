@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"俳諧", `"俳諧"`},
+		{"俳諧 和歌 elevel:OCR", `("俳諧" AND "和歌" AND elevel:"OCR")`},
+		{"俳諧,和歌", `("俳諧" AND "和歌")`},
+		{"俳諧　AND　和歌", `("俳諧" AND "和歌")`},
+		{"俳諧 OR 和歌 連歌", `("俳諧" OR ("和歌" AND "連歌"))`},
+		{"(俳諧 OR 和歌) NOT 連歌", `(("俳諧" OR "和歌") AND NOT "連歌")`},
+		{"（俳諧 OR 和歌）", `("俳諧" OR "和歌")`},
+		{`"月 日" tag:ndlocrv2`, `("月 日" AND tag:"ndlocrv2")`},
+		{`author:"鴨 長明" 無常`, `(author:"鴨 長明" AND "無常")`},
+		{"無常 year:1600..1699", `("無常" AND year:"1600..1699")`},
+		{"NOT NOT 無常", `NOT NOT "無常"`},
+		{"俳諧 NEAR/5 和歌", `("俳諧" NEAR/5 "和歌")`},
+		{"俳諧 NEAR 和歌 連歌", `(("俳諧" NEAR/10 "和歌") AND "連歌")`},
+		{"NOT 俳諧 NEAR/0 和歌", `NOT ("俳諧" NEAR/0 "和歌")`},
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Errorf("ParseQuery(%s): %s", tt.q, err)
+			continue
+		}
+		if got := n.String(); got != tt.want {
+			t.Errorf("ParseQuery(%s) => %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q   string
+		pos int
+	}{
+		{"", 1},
+		{"   ", 1},
+		{"(俳諧 OR 和歌", 10},
+		{"俳諧 OR", 6},
+		{"俳諧 )", 4},
+		{`俳諧 "和歌`, 4},
+		{"俳諧 AND AND 和歌", 8},
+		{"elvel:OCR 俳諧", 1},
+		{"elevel:XXX", 8},
+		{"俳諧 year:寛永", 9},
+		{"tag:", 1},
+		{"俳諧 NEAR/x 和歌", 9},
+		{"俳諧 NEAR tag:a", 9},
+		{"俳諧 NEAR 和歌 NEAR 連歌", 12},
+		{"NEAR 和歌", 1},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.q)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseQuery(%q) => %v, want *QueryError", tt.q, err)
+			continue
+		}
+		if qe.Pos != tt.pos {
+			t.Errorf("ParseQuery(%q) => %s, want position %d", tt.q, qe, tt.pos)
+		}
+	}
+}
+
+func TestQueryNodeESQuery(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("(俳諧 OR 和歌) NOT 連歌 tag:ndlocrv2 year:..1699")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(n.ESQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"bool":{"must":[` +
+		`{"bool":{"minimum_should_match":1,"should":[` +
+		`{"match_phrase":{"text":{"query":"俳諧"}}},` +
+		`{"match_phrase":{"text":{"query":"和歌"}}}]}},` +
+		`{"bool":{"must_not":[{"match_phrase":{"text":{"query":"連歌"}}}]}},` +
+		`{"term":{"tags":{"value":"ndlocrv2"}}},` +
+		`{"range":{"biblio.year":{"lte":1699}}}]}}`
+	if string(raw) != want {
+		t.Errorf("ESQuery =>\n%s\nwant\n%s", raw, want)
+	}
+}
+
+func TestQueryNodeNear(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("俳諧 NEAR/3 和歌 NOT 連歌")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.HasNear() {
+		t.Errorf("HasNear => false")
+	}
+
+	raw, err := json.Marshal(n.Children[0].ESQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"intervals":{"text":{"all_of":{"intervals":[` +
+		`{"match":{"max_gaps":0,"ordered":true,"query":"俳諧"}},` +
+		`{"match":{"max_gaps":0,"ordered":true,"query":"和歌"}}],` +
+		`"max_gaps":4,"ordered":false}}}}`
+	if string(raw) != want {
+		t.Errorf("ESQuery =>\n%s\nwant\n%s", raw, want)
+	}
+
+	raw, err = json.Marshal(n.HighlightQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"bool":{"should":[` +
+		`{"match_phrase":{"text":{"query":"俳諧"}}},` +
+		`{"match_phrase":{"text":{"query":"和歌"}}}]}}`
+	if string(raw) != want {
+		t.Errorf("HighlightQuery =>\n%s\nwant\n%s", raw, want)
+	}
+
+	if got := n.FragmentSize(5); got != 7 {
+		t.Errorf("FragmentSize(5) => %d, want 7", got)
+	}
+
+	tests := []struct {
+		t    string
+		want bool
+	}{
+		{"春の俳諧と秋の和歌", true},
+		{"和歌詠みて俳諧", true},
+		{"俳諧の連歌ならずして和歌", false},
+		{"俳諧のみ", false},
+	}
+	for _, tt := range tests {
+		if got := n.MatchesFragment(tt.t); got != tt.want {
+			t.Errorf("MatchesFragment(%s) => %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// Wildcards of text terms: "?" (or "？", "□") matches a character and
+// "*" (or "＊") up to wildcardMaxRun characters. The bigram index only
+// narrows down the candidates; the matches are verified over the text.
+// Fuzzy terms match the variants of the characters in confusionTable
+// as well, scored by the number of the characters substituted.
+
+// max characters of "*"
+const wildcardMaxRun = 5
+
+// character which NDL OCR writes for unreadable glyphs
+const unknownChar = '□'
+
+type wildcardItem struct {
+	// 0 for "?" and "*"
+	r    rune
+	star bool
+}
+
+// parseWildcard splits v into items
+func parseWildcard(v string) []wildcardItem {
+	items := []wildcardItem{}
+	for _, r := range v {
+		switch r {
+		case '?', '？', unknownChar:
+			items = append(items, wildcardItem{})
+		case '*', '＊':
+			items = append(items, wildcardItem{star: true})
+		default:
+			items = append(items, wildcardItem{r: r})
+		}
+	}
+	return items
+}
+
+// items returns the items of Value normalized as the text field does,
+// except the kana folded by chars
+func (n *QueryNode) items() []wildcardItem {
+	v, _ := NormalizeText(n.Value, false)
+	return parseWildcard(v)
+}
+
+func hasWildcard(v string) bool {
+	return strings.ContainsAny(v, "?？*＊□")
+}
+
+// IsWildcard reports whether n is a text term to match with wildcards,
+// including the one matching □ of the text or the variants
+func (n *QueryNode) IsWildcard() bool {
+	return n.Op == QueryTerm && n.Field == "" &&
+		(hasWildcard(n.Value) ||
+			(n.Unknown || n.Fuzzy) && utf8.RuneCountInString(n.Value) > 1)
+}
+
+// HasWildcard reports whether n has a positive wildcard term
+func (n *QueryNode) HasWildcard() bool {
+	for _, u := range n.units() {
+		if u.IsWildcard() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetUnknown lets □ of the text match any character of the positive
+// text terms except those of NEARs
+func (n *QueryNode) SetUnknown() {
+	for _, u := range n.units() {
+		if u.Op == QueryTerm {
+			u.Unknown = true
+		}
+	}
+}
+
+// SetFuzzy lets the positive text terms except those of NEARs match the
+// variants of the characters
+func (n *QueryNode) SetFuzzy() {
+	for _, u := range n.units() {
+		if u.Op == QueryTerm {
+			u.Fuzzy = true
+		}
+	}
+}
+
+// chars returns the characters it matches
+func (n *QueryNode) chars(it wildcardItem) []rune {
+	rs := []rune{it.r}
+	if n.Fuzzy {
+		rs = currentConfusionTable().Variants(it.r)
+	}
+	folded := []rune{}
+	for _, r := range rs {
+		f := currentItaijiTable().Fold(r)
+		if n.Kana {
+			f = FoldKanaRune(f)
+		}
+		if !slices.Contains(folded, f) {
+			folded = append(folded, f)
+		}
+	}
+	rs = folded
+	if n.Unknown {
+		rs = append(rs, unknownChar)
+	}
+	return rs
+}
+
+// checkWildcard returns an error for a term with no character
+func (n *QueryNode) checkWildcard() error {
+	for _, it := range parseWildcard(n.Value) {
+		if it.r != 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("character expected besides wildcards")
+}
+
+// wildcardESQuery returns the query of the bigrams of n, whose positions
+// are not checked
+func (n *QueryNode) wildcardESQuery() types.Query {
+	chars := func(it wildcardItem) []string {
+		if it.r == 0 {
+			return []string{"?"}
+		}
+		ss := []string{}
+		for _, r := range n.chars(it) {
+			ss = append(ss, string(r))
+		}
+		return ss
+	}
+	bigram := func(a, b wildcardItem) types.Query {
+		qs := []types.Query{}
+		for _, x := range chars(a) {
+			for _, y := range chars(b) {
+				qs = append(qs, bigramQuery(n.TextField(), x+y))
+			}
+		}
+		if len(qs) == 1 {
+			return qs[0]
+		}
+		return types.Query{Bool: &types.BoolQuery{Should: qs, MinimumShouldMatch: 1}}
+	}
+
+	qs := []types.Query{}
+	items := n.items()
+	for i := 0; i < len(items); {
+		// segment between "*"s
+		j := i
+		for j < len(items) && !items[j].star {
+			j++
+		}
+		seg := items[i:j]
+		if len(seg) == 1 && seg[0].r != 0 {
+			// a character alone: either side
+			other := wildcardItem{}
+			qs = append(qs, types.Query{Bool: &types.BoolQuery{
+				Should:             []types.Query{bigram(seg[0], other), bigram(other, seg[0])},
+				MinimumShouldMatch: 1,
+			}})
+		}
+		for k := 0; k+1 < len(seg); k++ {
+			if seg[k].r != 0 || seg[k+1].r != 0 {
+				qs = append(qs, bigram(seg[k], seg[k+1]))
+			}
+		}
+		i = j + 1
+	}
+	return types.Query{Bool: &types.BoolQuery{Must: qs}}
+}
+
+func bigramQuery(field, s string) types.Query {
+	if strings.Contains(s, "?") {
+		return types.Query{
+			Wildcard: map[string]types.WildcardQuery{
+				field: {Value: Str2Pt(s)},
+			},
+		}
+	}
+	return types.Query{
+		MatchPhrase: map[string]types.MatchPhraseQuery{
+			field: {Query: s},
+		},
+	}
+}
+
+// Regexp returns the regexp of a wildcard term, to match the text folded
+// as the text field does
+func (n *QueryNode) Regexp() *regexp.Regexp {
+	return regexp.MustCompile("(?s)" + n.regexpSource())
+}
+
+// regexpSource returns the regexp with a group for each character
+func (n *QueryNode) regexpSource() string {
+	var sb strings.Builder
+	for _, it := range n.items() {
+		switch {
+		case it.star:
+			fmt.Fprintf(&sb, ".{0,%d}", wildcardMaxRun)
+		case it.r == 0:
+			sb.WriteString(".")
+		default:
+			sb.WriteString("([")
+			for _, r := range n.chars(it) {
+				fmt.Fprintf(&sb, `\x{%x}`, r)
+			}
+			sb.WriteString("])")
+		}
+	}
+	return sb.String()
+}
+
+// EditDistance returns the number of the characters of the term n which are
+// substituted in the match m, by variants or □
+func (n *QueryNode) EditDistance(m string) int {
+	m = n.normalizeText(m)
+	sm := regexp.MustCompile("(?s)^" + n.regexpSource() + "$").FindStringSubmatch(m)
+	if sm == nil {
+		return 0
+	}
+	d := 0
+	i := 1
+	for _, it := range n.items() {
+		if it.r == 0 {
+			continue
+		}
+		if sm[i] != string(n.chars(it)[0]) {
+			d++
+		}
+		i++
+	}
+	return d
+}
+
+// MatchesText verifies the wildcard terms of n against text; false only
+// if text surely does not match, as the other terms are left to ES
+func (n *QueryNode) MatchesText(text string) bool {
+	text = n.normalizeText(text)
+	m, known := n.matchText(text)
+	return m || !known
+}
+
+func (n *QueryNode) matchText(text string) (match, known bool) {
+	switch n.Op {
+	case QueryTerm:
+		if !n.IsWildcard() {
+			return false, false
+		}
+		return n.Regexp().MatchString(text), true
+	case QueryNear:
+		return false, false
+	case QueryNot:
+		m, k := n.Children[0].matchText(text)
+		return !m, k
+	case QueryAnd:
+		match, known = true, true
+		for _, c := range n.Children {
+			m, k := c.matchText(text)
+			if k && !m {
+				return false, true
+			}
+			known = known && k
+		}
+		return match, known
+	case QueryOr:
+		match, known = false, true
+		for _, c := range n.Children {
+			m, k := c.matchText(text)
+			if k && m {
+				return true, true
+			}
+			known = known && k
+		}
+		return match, known
+	}
+	return false, false
+}
+
+// wildcardUnit returns the wildcard term of the highlight class key
+// "hltwN"; nil for the other classes
+func (n *QueryNode) wildcardUnit(key string) *QueryNode {
+	k, err := strconv.Atoi(strings.TrimPrefix(key, "hltw"))
+	if !strings.HasPrefix(key, "hltw") || err != nil {
+		return nil
+	}
+	for _, u := range n.units() {
+		if !u.IsWildcard() {
+			continue
+		}
+		if k--; k == 0 {
+			return u
+		}
+	}
+	return nil
+}
+
+// MatchDistance returns the distance of word highlighted with the class
+// key from its term; false unless key is of a wildcard term
+func (n *QueryNode) MatchDistance(key, word string) (int, bool) {
+	u := n.wildcardUnit(key)
+	if u == nil {
+		return 0, false
+	}
+	return u.EditDistance(word), true
+}
+
+// WildcardFragments returns the fragments of the matches of the wildcard
+// terms in text, highlighted as the fvh does with the class "hltwN"
+// (N: 1-origin index of the wildcard terms)
+func (n *QueryNode) WildcardFragments(text string, size int) []string {
+	// src maps the offsets in the normalized text to those in text
+	normalized, src := NormalizeText(text, n.Kana)
+	frags := []string{}
+	k := 0
+	for _, u := range n.units() {
+		if !u.IsWildcard() {
+			continue
+		}
+		k++
+		for _, loc := range u.Regexp().FindAllStringIndex(normalized, -1) {
+			mb, me := src[loc[0]][0], src[loc[1]-1][1]
+			frags = append(frags, highlightFragment(text, [][2]int{{mb, me}}, -1, size, fmt.Sprintf("hltw%d", k)).Match)
+		}
+	}
+	return frags
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func TestWildcardRegexp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q       string
+		unknown bool
+		text    string
+		want    bool
+	}{
+		{"御?上", false, "御申上", true},
+		{"御□上", false, "御申上", true},
+		{"御？上", false, "御□上", true},
+		{"御申上", true, "御□上", true},
+		{"御*上", false, "御申上", true},
+		{"御*上", false, "御上", true},
+		{"御*上", false, "御一二三四五六上", false},
+		{"御?上", false, "御上", false},
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Errorf("ParseQuery(%s): %s", tt.q, err)
+			continue
+		}
+		if tt.unknown {
+			n.SetUnknown()
+		}
+		if !n.IsWildcard() {
+			t.Errorf("%s: IsWildcard => false", tt.q)
+			continue
+		}
+		if got := n.MatchesText(tt.text); got != tt.want {
+			t.Errorf("%s (unknown=%v): MatchesText(%s) => %v, want %v",
+				tt.q, tt.unknown, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWildcardPlain(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("御申上")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.IsWildcard() {
+		t.Errorf("IsWildcard => true, want false")
+	}
+	n.SetUnknown()
+	if !n.IsWildcard() {
+		t.Errorf("IsWildcard with unknown => false, want true")
+	}
+}
+
+func TestWildcardParseError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		q   string
+		pos int
+	}{
+		{"??", 1},
+		{"俳諧 *", 4},
+		{"俳諧 NOT 御?上", 8},
+		{"俳諧 NEAR 御?上", 9},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.q)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseQuery(%q) => %v, want *QueryError", tt.q, err)
+			continue
+		}
+		if qe.Pos != tt.pos {
+			t.Errorf("ParseQuery(%q) => %s, want position %d", tt.q, qe, tt.pos)
+		}
+	}
+}
+
+func TestWildcardESQuery(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("御?上*候")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(n.ESQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"bool":{"must":[` +
+		`{"wildcard":{"text":{"value":"御?"}}},` +
+		`{"wildcard":{"text":{"value":"?上"}}},` +
+		`{"bool":{"minimum_should_match":1,"should":[` +
+		`{"wildcard":{"text":{"value":"候?"}}},` +
+		`{"wildcard":{"text":{"value":"?候"}}}]}}]}}`
+	if string(raw) != want {
+		t.Errorf("ESQuery =>\n%s\nwant\n%s", raw, want)
+	}
+}
+
+func TestWildcardMatches(t *testing.T) {
+	t.Parallel()
+
+	n, err := ParseQuery("(御?上 OR tag:a) 候")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tag:a is left to ES
+	if !n.MatchesText("候") {
+		t.Errorf("MatchesText => false, want true")
+	}
+
+	n, err = ParseQuery("御?上 候")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.MatchesText("御上候") {
+		t.Errorf("MatchesText => true, want false")
+	}
+
+	got := n.WildcardFragments("一二三御申上候四五六七御□上", 7)
+	want := []string{
+		`二三<em class="hltw1">御申上</em>候四`,
+		`六七<em class="hltw1">御□上</em>`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("WildcardFragments mismatch (-want +got):\n%s", diff)
+	}
+	if !n.MatchesFragment("三御□上候") {
+		t.Errorf("MatchesFragment => false, want true")
+	}
+}
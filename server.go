@@ -17,6 +17,32 @@ func main() {
 	}
 	cfg = c
 
+	// manifest
+	mp, err := NewManifestProvider()
+	if err != nil {
+		log.Fatal("manifest: ", err)
+	}
+	manifestProvider = mp
+	manifestFetcher = NewManifestFetcher()
+	manifestSources = NewManifestSources()
+	if err := CheckMetadataFields(); err != nil {
+		log.Fatal("config: ", err)
+	}
+	if cfg.ConfusionFile != "" {
+		ct, err := LoadConfusionTable(cfg.ConfusionFile)
+		if err != nil {
+			log.Fatal("config: ", err)
+		}
+		setConfusionTable(ct)
+	}
+	if cfg.ItaijiFile != "" {
+		t, err := LoadItaijiTable(cfg.ItaijiFile)
+		if err != nil {
+			log.Fatal("config: ", err)
+		}
+		setItaijiTable(t)
+	}
+
 	// elasticsearch
 	var es = &ES{}
 	if err := es.Init(); err != nil {
@@ -36,8 +62,14 @@ func main() {
 	api.GET("/ocrraw/:id", GetOCRRaw(es))
 	api.GET("/countRecord", GetCount(es))
 	api.GET("/search", GetNgramSearch(es))
+	api.GET("/formats", GetFormats())
+	api.GET("/itaiji", GetItaiji())
 	api.POST("/register", PostRegister(es))
 	api.POST("/bulkRegister", PostBulkRegister(es))
+	api.POST("/metadata/refresh", PostMetadataRefresh(es))
+	api.POST("/itaiji", PostItaiji(es))
+	api.POST("/itaiji/reload", PostItaijiReload(es))
+	api.POST("/confusion/reload", PostConfusionReload(es))
 
 	e.Logger.Fatal(e.Start(":1323"))
 }
@@ -5,6 +5,7 @@ import (
 )
 
 const (
-	srcDir    string = "testdata/src"
-	expectDir string = "testdata/expect"
+	srcDir      string = "testdata/src"
+	expectDir   string = "testdata/expect"
+	manifestDir string = "testdata/manifest"
 )
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/shogo82148/go-mecab"
 )
@@ -21,7 +22,50 @@ func Str2Pt(s string) *string {
 	return &s
 }
 
-func MecabFilter(mecabType, text string) ([]string, error) {
+func Bool2Pt(b bool) *bool {
+	return &b
+}
+
+// index of "kana" (reading in katakana) of the UniDic features
+const mecabKanaFeature = 20
+
+/* MecabTokens */
+// MecabTokens are the tokens of a text by MeCab
+type MecabTokens struct {
+	// "pos1:pos2:pos3:pos4:lemma", or the surface if unknown
+	Keys []string
+	// readings in hiragana, or the surfaces if unknown
+	Readings []string
+	// [offset, length] (runes) in the text; see tokenSpans
+	Spans [][2]int
+}
+
+// Reading returns the readings joined and the spans of the tokens in it
+func (mt *MecabTokens) Reading() (string, [][2]int) {
+	var sb strings.Builder
+	spans := make([][2]int, len(mt.Readings))
+	pos := 0
+	for i, r := range mt.Readings {
+		n := utf8.RuneCountInString(r)
+		spans[i] = [2]int{pos, n}
+		sb.WriteString(r)
+		pos += n
+	}
+	return sb.String(), spans
+}
+
+// MecabReading returns the reading of text in hiragana
+func MecabReading(mecabType, text string) (string, error) {
+	mt, err := MecabFilter(mecabType, text)
+	if err != nil {
+		return "", err
+	}
+	r, _ := mt.Reading()
+	return r, nil
+}
+
+// MecabFilter returns the tokens of text
+func MecabFilter(mecabType, text string) (*MecabTokens, error) {
 	mecabTypes := []string{
 		"jodai",
 		"chuko",
@@ -55,17 +99,45 @@ func MecabFilter(mecabType, text string) ([]string, error) {
 		return nil, fmt.Errorf("mecab parse error: %s", err)
 	}
 
-	keys := []string{}
+	mt := &MecabTokens{}
+	surfaces := []string{}
 	for ; !node.IsZero(); node = node.Next() {
 		f := strings.Split(node.Feature(), ",")
+		reading := node.Surface()
 		if len(f) < 27 {
-			keys = append(keys, node.Surface())
+			mt.Keys = append(mt.Keys, node.Surface())
 		} else {
-			keys = append(keys, f[0]+":"+f[1]+":"+f[2]+":"+f[3]+":"+f[10])
+			mt.Keys = append(mt.Keys, f[0]+":"+f[1]+":"+f[2]+":"+f[3]+":"+f[10])
+			if k := f[mecabKanaFeature]; k != "" && k != "*" {
+				reading = k
+			}
 		}
+		mt.Readings = append(mt.Readings, ToHiragana(reading))
+		surfaces = append(surfaces, node.Surface())
 	}
+	mt.Spans = tokenSpans(text, surfaces)
 
-	return keys, nil
+	return mt, nil
+}
+
+// tokenSpans returns [offset, length] (runes) in text of the surfaces in
+// order; [-1, 0] if not found
+func tokenSpans(text string, surfaces []string) [][2]int {
+	spans := make([][2]int, len(surfaces))
+	pos, rpos := 0, 0
+	for i, s := range surfaces {
+		idx := strings.Index(text[pos:], s)
+		if s == "" || idx == -1 {
+			spans[i] = [2]int{-1, 0}
+			continue
+		}
+		rpos += utf8.RuneCountInString(text[pos : pos+idx])
+		n := utf8.RuneCountInString(s)
+		spans[i] = [2]int{rpos, n}
+		pos += idx + len(s)
+		rpos += n
+	}
+	return spans
 }
 
 func unzipUploaded(file *multipart.FileHeader, destdir string) error {